@@ -1,13 +1,21 @@
 package cache
 
 import (
-	"fmt"
+	"errors"
+	"math/rand"
 	"regexp"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Cache struct {
+	mu    sync.RWMutex
 	cache map[string]*val
+	// Number of changes since the dataset was last saved or loaded.
+	dirty int
+	// Set when DEBUG SET-ACTIVE-EXPIRE has disabled active expiry.
+	noActiveExpire atomic.Bool
 }
 
 var defaultCache *Cache = &Cache{cache: map[string]*val{}}
@@ -16,33 +24,101 @@ func GetDefaultCache() *Cache {
 	return defaultCache
 }
 
+// Values are stored as one of:
+// * string -- String
+// * []string -- List
+// * map[string]struct{} -- Set
+// * map[string]float64 -- Sorted Set, member => score
+// * map[string]string -- Hash, field => value
 type val struct {
-	val string
+	val interface{}
 	exp time.Time
+	// Eviction metadata: when the key was last accessed, and its logarithmic
+	// LFU access counter.
+	lru time.Time
+	lfu uint8
 }
 
+// LFU counters start here, so new keys aren't evicted before they're used.
+const lfuInitVal = 5
+
+// Controls how many accesses it takes to saturate the LFU counter.
+const lfuLogFactor = 10
+
+// touch records an access to `v`.
+func (v *val) touch() {
+	v.lru = time.Now()
+	if v.lfu == 255 {
+		return
+	}
+	// Increment with probability 1/((counter-init)*factor+1), so the counter
+	// grows logarithmically with the number of accesses.
+	base := float64(v.lfu) - lfuInitVal
+	if base < 0 {
+		base = 0
+	}
+	if rand.Float64() < 1/(base*lfuLogFactor+1) {
+		v.lfu++
+	}
+}
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 func (v *val) isExpired() bool {
 	return !v.exp.IsZero() && time.Now().After(v.exp)
 }
 
+// Entry is a point-in-time copy of a key, its value, and optional expiry.
+// Collection values are shared rather than copied, since they're never
+// modified in place.
+type Entry struct {
+	Key    string
+	Value  interface{}
+	Expiry time.Time
+}
+
 func (c *Cache) KeyExists(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	val, exists := c.cache[key]
 	return exists && !val.isExpired()
 }
 
-func (c *Cache) Get(key string) (string, bool) {
+// Get returns the string value of `key`, or ErrWrongType if it holds some
+// other type of value.
+func (c *Cache) Get(key string) (string, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	val, ok := c.cache[key]
 	if !ok {
-		return "", false
+		return "", false, nil
 	}
 	if val.isExpired() {
 		delete(c.cache, key)
-		return "", false
+		return "", false, nil
+	}
+	str, ok := val.val.(string)
+	if !ok {
+		return "", false, ErrWrongType
 	}
-	return val.val, ok
+	val.touch()
+	return str, true, nil
+}
+
+// Type returns the name of the type of value stored at `key`, or "none".
+func (c *Cache) Type(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	val, ok := c.cache[key]
+	if !ok || val.isExpired() {
+		return "none"
+	}
+	return typeOf(val.val)
 }
 
 func (c *Cache) GetKeys(pattern *regexp.Regexp) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	var keys []string
 	for k := range c.cache {
 		if pattern.Match([]byte(k)) {
@@ -53,49 +129,65 @@ func (c *Cache) GetKeys(pattern *regexp.Regexp) []string {
 }
 
 func (c *Cache) Set(key string, value string, expiry time.Time) {
-	c.cache[key] = &val{val: value, exp: expiry}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, expiry)
+	c.dirty++
 }
 
-// elems is a slice of slices. Each slice is a k/v pair with an optional expiry -- k, v[, e]
-func (c *Cache) LoadRDB(resp interface{}) error {
-	if resp == nil {
-		return nil
-	}
+func (c *Cache) set(key string, value interface{}, expiry time.Time) {
+	c.cache[key] = &val{val: value, exp: expiry, lru: time.Now(), lfu: lfuInitVal}
+}
 
-	elems, ok := resp.([][]interface{})
-	if !ok {
-		return fmt.Errorf("unexpected RDBParser response format")
+// Delete removes `keys`, returning the number that existed.
+func (c *Cache) Delete(keys ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, key := range keys {
+		val, ok := c.cache[key]
+		if !ok {
+			continue
+		}
+		delete(c.cache, key)
+		if !val.isExpired() {
+			n++
+			c.dirty++
+		}
 	}
-	// Dump current cache
-	c.cache = make(map[string]*val)
-
-	for _, elem := range elems {
-		var (
-			key, val []byte
-			expiry   time.Time
-			ok       bool
-		)
-		switch len(elem) {
-		case 3: // k/v with expiry
-			expiry, ok = elem[2].(time.Time)
-			if !ok {
-				return fmt.Errorf("wrong time format: %#v", elem[2])
-			}
-			fallthrough
-		case 2: // k/v
-			key, ok = elem[0].([]byte)
-			if !ok {
-				return fmt.Errorf("improper key type: %#v", elem[0])
-			}
-			val, ok = elem[1].([]byte)
-			if !ok {
-				return fmt.Errorf("improper val type: %#v", elem[0])
-			}
-		default: // unexpected length
-			return fmt.Errorf("unexpected k/v pair length %#v", len(elem))
+	return n
+}
+
+// Snapshot returns a copy of every live key, along with the number of changes
+// made since the last save; pass the latter to MarkSaved once the snapshot has
+// been persisted.
+func (c *Cache) Snapshot() ([]Entry, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make([]Entry, 0, len(c.cache))
+	for k, v := range c.cache {
+		if v.isExpired() {
+			continue
 		}
-		// Load into cache.
-		c.Set(string(key), string(val), expiry)
+		entries = append(entries, Entry{Key: k, Value: v.val, Expiry: v.exp})
+	}
+	return entries, c.dirty
+}
+
+// Dirty returns the number of changes since the dataset was last saved.
+func (c *Cache) Dirty() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dirty
+}
+
+// MarkSaved records that the `dirty` changes reported by Snapshot have been
+// saved. Changes made since the snapshot still count as unsaved.
+func (c *Cache) MarkSaved(dirty int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirty -= dirty
+	if c.dirty < 0 {
+		c.dirty = 0
 	}
-	return nil
 }
@@ -0,0 +1,70 @@
+package cache
+
+import "time"
+
+// Active expiry periodically samples keys with an expiry and deletes those
+// that have expired, so keys that are never accessed again still get freed.
+const (
+	// How often an active expiry cycle should run.
+	ActiveExpireInterval = 100 * time.Millisecond
+	// Keys with an expiry checked per sample, and keys looked at in total,
+	// so datasets with few expiring keys aren't scanned in full.
+	activeExpireSample = 20
+	activeExpireScan   = 20 * activeExpireSample
+	// Sampling repeats while more than this fraction of a sample had
+	// expired, for at most activeExpireBudget per cycle.
+	activeExpireRepeat = 0.25
+	activeExpireBudget = 25 * time.Millisecond
+)
+
+// SetActiveExpire enables or disables active expiry. Expired keys are still
+// deleted when accessed.
+func (c *Cache) SetActiveExpire(enabled bool) {
+	c.noActiveExpire.Store(!enabled)
+}
+
+// ActiveExpireCycle runs an active expiry cycle, returning the keys it
+// deleted. It does nothing if active expiry is disabled.
+func (c *Cache) ActiveExpireCycle() []string {
+	if c.noActiveExpire.Load() {
+		return nil
+	}
+	var keys []string
+	deadline := time.Now().Add(activeExpireBudget)
+	for time.Now().Before(deadline) {
+		before := len(keys)
+		var sampled int
+		sampled, keys = c.expireSample(keys)
+		if sampled == 0 || float64(len(keys)-before) <= activeExpireRepeat*float64(sampled) {
+			break
+		}
+	}
+	return keys
+}
+
+// expireSample checks up to activeExpireSample keys with an expiry, deleting
+// any that have expired and appending them to `keys`. Map iteration starts at
+// a random point, so each call samples different keys.
+func (c *Cache) expireSample(keys []string) (int, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	scanned, sampled := 0, 0
+	for k, v := range c.cache {
+		if scanned++; scanned > activeExpireScan {
+			break
+		}
+		if v.exp.IsZero() {
+			continue
+		}
+		sampled++
+		if now.After(v.exp) {
+			delete(c.cache, k)
+			keys = append(keys, k)
+		}
+		if sampled == activeExpireSample {
+			break
+		}
+	}
+	return sampled, keys
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+)
+
+// Limits up to which small collections would use a compact encoding, as in
+// Redis' default config.
+const (
+	maxListpackEntries = 128
+	maxListpackValue   = 64
+	maxIntsetEntries   = 512
+	maxEmbstrLen       = 44
+)
+
+// typeOf returns the name of the type of `v`, as reported by TYPE.
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]struct{}:
+		return "set"
+	case map[string]float64:
+		return "zset"
+	case map[string]string:
+		return "hash"
+	default:
+		return "none"
+	}
+}
+
+// encodingOf returns the encoding Redis would use to store `v`.
+func encodingOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(i, 10) == v {
+			return "int"
+		}
+		if len(v) <= maxEmbstrLen {
+			return "embstr"
+		}
+		return "raw"
+	case []string:
+		if fitsListpack(len(v), v...) {
+			return "listpack"
+		}
+		return "quicklist"
+	case map[string]struct{}:
+		if len(v) <= maxIntsetEntries {
+			ints := true
+			for m := range v {
+				if _, err := strconv.ParseInt(m, 10, 64); err != nil {
+					ints = false
+					break
+				}
+			}
+			if ints {
+				return "intset"
+			}
+		}
+		if len(v) <= maxListpackEntries {
+			members := make([]string, 0, len(v))
+			for m := range v {
+				members = append(members, m)
+			}
+			if fitsListpack(len(v), members...) {
+				return "listpack"
+			}
+		}
+		return "hashtable"
+	case map[string]float64:
+		if len(v) <= maxListpackEntries {
+			members := make([]string, 0, len(v))
+			for m := range v {
+				members = append(members, m)
+			}
+			if fitsListpack(len(v), members...) {
+				return "listpack"
+			}
+		}
+		return "skiplist"
+	case map[string]string:
+		if len(v) <= maxListpackEntries {
+			elems := make([]string, 0, 2*len(v))
+			for f, val := range v {
+				elems = append(elems, f, val)
+			}
+			if fitsListpack(len(v), elems...) {
+				return "listpack"
+			}
+		}
+		return "hashtable"
+	default:
+		return "unknown"
+	}
+}
+
+// fitsListpack returns true if `n` entries of `elems` are few and small enough
+// for a listpack.
+func fitsListpack(n int, elems ...string) bool {
+	if n > maxListpackEntries {
+		return false
+	}
+	for _, e := range elems {
+		if len(e) > maxListpackValue {
+			return false
+		}
+	}
+	return true
+}
+
+// ObjectInfo describes how a key is stored, for DEBUG OBJECT.
+type ObjectInfo struct {
+	Entry
+	Encoding string
+	// Time since the key was last accessed, and its LFU counter.
+	Idle time.Duration
+	Freq uint8
+}
+
+// Object returns information about the value stored at `key`, without
+// counting as an access.
+func (c *Cache) Object(key string) (ObjectInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	val, ok := c.cache[key]
+	if !ok || val.isExpired() {
+		return ObjectInfo{}, false
+	}
+	return ObjectInfo{
+		Entry:    Entry{Key: key, Value: val.val, Expiry: val.exp},
+		Encoding: encodingOf(val.val),
+		Idle:     time.Since(val.lru),
+		Freq:     val.lfu,
+	}, true
+}
+
+// KeyspaceStats counts live keys, keys with an expiry, and keys of each type.
+func (c *Cache) KeyspaceStats() (keys, expires int, types map[string]int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	types = make(map[string]int)
+	for _, v := range c.cache {
+		if v.isExpired() {
+			continue
+		}
+		keys++
+		if !v.exp.IsZero() {
+			expires++
+		}
+		types[typeOf(v.val)]++
+	}
+	return keys, expires, types
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// LoadRDB replaces the cache's contents with the RDB file read from
+// `dbfile`, returning the file's metadata. The file's loaded in full before
+// it replaces the cache, which is left as is on error, and serves reads and
+// writes until then.
+func (c *Cache) LoadRDB(dbfile io.Reader) (parser.RDBInfo, error) {
+	loaded := make(map[string]*val)
+	now := time.Now()
+	info, err := parser.ParseRDB(dbfile, func(e parser.RDBEntry) error {
+		value, err := fromRDBValue(e.Value)
+		if err != nil {
+			return err
+		}
+		v := &val{val: value, exp: e.Expiry, lru: now, lfu: lfuInitVal}
+		if e.HasIdle {
+			v.lru = now.Add(-e.Idle)
+		}
+		if e.HasFreq {
+			v.lfu = e.Freq
+		}
+		loaded[string(e.Key)] = v
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = loaded
+	c.dirty = 0
+	return info, nil
+}
+
+var ErrBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+// DumpValue returns the value stored at `key` in its RDB form, along with its
+// expiry, for DUMP and MIGRATE.
+func (c *Cache) DumpValue(key string) (interface{}, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	val, ok := c.cache[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	if val.isExpired() {
+		delete(c.cache, key)
+		return nil, time.Time{}, false
+	}
+	val.touch()
+	return toRDBValue(val.val), val.exp, true
+}
+
+// Restore stores the value of `e` at its key, along with its expiry and any
+// LRU/LFU info. Returns ErrBusyKey if the key exists, unless `replace` is
+// set. A value that's already expired just deletes the key.
+func (c *Cache) Restore(e parser.RDBEntry, replace bool) error {
+	val, err := fromRDBValue(e.Value)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(e.Key)
+	if v, ok := c.cache[key]; ok && !v.isExpired() && !replace {
+		return ErrBusyKey
+	}
+	c.dirty++
+	if !e.Expiry.IsZero() && time.Now().After(e.Expiry) {
+		delete(c.cache, key)
+		return nil
+	}
+	c.set(key, val, e.Expiry)
+	if e.HasIdle {
+		c.cache[key].lru = time.Now().Add(-e.Idle)
+	}
+	if e.HasFreq {
+		c.cache[key].lfu = e.Freq
+	}
+	return nil
+}
+
+// fromRDBValue converts a value from the RDB parser into its cache type.
+func fromRDBValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []byte:
+		return string(v), nil
+	case parser.RDBList:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			list = append(list, string(e))
+		}
+		return list, nil
+	case parser.RDBSet:
+		set := make(map[string]struct{}, len(v))
+		for _, e := range v {
+			set[string(e)] = struct{}{}
+		}
+		return set, nil
+	case parser.RDBSortedSet:
+		zset := make(map[string]float64, len(v))
+		for _, e := range v {
+			zset[string(e.Member)] = e.Score
+		}
+		return zset, nil
+	case parser.RDBHash:
+		hash := make(map[string]string, len(v))
+		for _, e := range v {
+			hash[string(e.Field)] = string(e.Value)
+		}
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("improper val type: %#v", v)
+	}
+}
+
+// RDBEntry converts `e` for the RDB writer.
+func (e Entry) RDBEntry() parser.RDBEntry {
+	return parser.RDBEntry{Key: []byte(e.Key), Value: toRDBValue(e.Value), Expiry: e.Expiry}
+}
+
+// toRDBValue converts a value from its cache type for the RDB writer.
+func toRDBValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []string:
+		list := make(parser.RDBList, 0, len(v))
+		for _, e := range v {
+			list = append(list, []byte(e))
+		}
+		return list
+	case map[string]struct{}:
+		set := make(parser.RDBSet, 0, len(v))
+		for e := range v {
+			set = append(set, []byte(e))
+		}
+		return set
+	case map[string]float64:
+		zset := make(parser.RDBSortedSet, 0, len(v))
+		for member, score := range v {
+			zset = append(zset, parser.RDBSortedSetEntry{Member: []byte(member), Score: score})
+		}
+		return zset
+	case map[string]string:
+		hash := make(parser.RDBHash, 0, len(v))
+		for field, value := range v {
+			hash = append(hash, parser.RDBHashEntry{Field: []byte(field), Value: []byte(value)})
+		}
+		return hash
+	default:
+		return v
+	}
+}
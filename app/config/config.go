@@ -2,41 +2,105 @@ package config
 
 import (
 	"flag"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/exp/rand"
 )
 
 var (
-	dbfilename string
-	dir        string
-	port       string
-	replicaof  string
+	appenddirname  string
+	appendfilename string
+	appendfsync    string
+	appendonly     string
+	dbfilename     string
+	dir            string
+	enableDebug    string
+	eventLoop      string
+	ioThreads      string
+	minReplicas    string
+	minReplicasLag string
+	port           string
+	rdbcompression string
+	replBacklog    string
+	replDiskless   string
+	replPingPeriod string
+	replTimeout    string
+	replicaLimit   string
+	replicaof      string
+	replicaRO      string
+	save           string
 
+	shutdownTimeout string
+
+	// Guards config, which is also updated as the server runs (e.g. the
+	// replication offset).
+	mu     sync.RWMutex
 	config cfg = make(cfg)
 )
 
 type cfg map[string]string
 
 func Get(key string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
 	v, ok := config[key]
 	return v, ok
 }
 
 func Set(key, value string) {
+	mu.Lock()
+	defer mu.Unlock()
 	config[key] = value
 }
 
 func ParseCLIFlags() {
 	rand.Seed(uint64(time.Now().UnixNano()))
+	flag.StringVar(&appendonly, "appendonly", "no", "log every write command to the append only file (yes|no)")
+	flag.StringVar(&appendfilename, "appendfilename", "appendonly.aof", "base name of the append only files")
+	flag.StringVar(&appenddirname, "appenddirname", "appendonlydir", "directory, within dir, where the append only files are stored")
+	flag.StringVar(&appendfsync, "appendfsync", "everysec", "when to fsync the append only file (always|everysec|no)")
 	flag.StringVar(&dbfilename, "dbfilename", "dump.rdb", "name of the RDB file")
 	flag.StringVar(&dir, "dir", "/tmp/redis-files", "directory where the RDB file is stored")
 	flag.StringVar(&port, "port", "", "port on which to listen")
+	flag.StringVar(&rdbcompression, "rdbcompression", "yes", "compress strings with LZF when writing RDB files (yes|no)")
+	flag.StringVar(&replBacklog, "repl-backlog-size", "1048576", "bytes of the replication stream kept for replicas to partially resynchronize")
+	flag.StringVar(&replDiskless, "repl-diskless-sync", "yes", "stream the dataset to replicas as it's encoded, rather than building it in memory first (yes|no)")
+	flag.StringVar(&replPingPeriod, "repl-ping-replica-period", "10", "seconds between the PINGs a master sends its replicas")
+	flag.StringVar(&replTimeout, "repl-timeout", "60", "seconds without hearing from the other side before a replication link is dropped")
+	flag.StringVar(&replicaLimit, "replica-output-buffer-limit", "268435456", "bytes of replication stream queued for a replica before it's disconnected")
 	flag.StringVar(&replicaof, "replicaof", "", "<MASTER HOST> <MASTER PORT>")
+	flag.StringVar(&replicaRO, "replica-read-only", "yes", "reject write commands from clients while a replica (yes|no)")
+	flag.StringVar(&enableDebug, "enable-debug-command", "no", "allow the DEBUG command from any client, or only local ones (yes|local|no)")
+	flag.StringVar(&eventLoop, "event-loop", "no", "serve clients from an epoll reactor instead of a goroutine per connection (yes|no)")
+	flag.StringVar(&ioThreads, "io-threads", "4", "number of I/O threads used by the event loop")
+	flag.StringVar(&minReplicas, "min-replicas-to-write", "0", "refuse writes unless this many replicas are connected and lagging no more than min-replicas-max-lag (0 to disable)")
+	flag.StringVar(&minReplicasLag, "min-replicas-max-lag", "10", "seconds since a replica's last ACK for it to count towards min-replicas-to-write")
+	flag.StringVar(&save, "save", "", "save points, as \"<seconds> <changes> [<seconds> <changes> ...]\"")
+	flag.StringVar(&shutdownTimeout, "shutdown-timeout", "10", "seconds to wait for replicas to catch up when shutting down")
 	flag.Parse()
+	Set("appendonly", appendonly)
+	Set("appendfilename", appendfilename)
+	Set("appenddirname", appenddirname)
+	Set("appendfsync", appendfsync)
 	Set("dir", dir)
 	Set("dbfilename", dbfilename)
+	Set("rdbcompression", rdbcompression)
+	Set("repl-backlog-size", replBacklog)
+	Set("repl-diskless-sync", replDiskless)
+	Set("repl-ping-replica-period", replPingPeriod)
+	Set("repl-timeout", replTimeout)
+	Set("replica-output-buffer-limit", replicaLimit)
 	Set("replicaof", replicaof)
+	Set("replica-read-only", replicaRO)
+	Set("enable-debug-command", enableDebug)
+	Set("event-loop", eventLoop)
+	Set("io-threads", ioThreads)
+	Set("min-replicas-to-write", minReplicas)
+	Set("min-replicas-max-lag", minReplicasLag)
+	Set("save", save)
+	Set("shutdown-timeout", shutdownTimeout)
 	if port == "" {
 		// Set default port; depends on replicaof status.
 		if replicaof == "" {
@@ -46,11 +110,16 @@ func ParseCLIFlags() {
 		}
 	}
 	Set("port", port)
-	Set("master_replid", generateID())
+	Set("master_replid", GenerateID())
 	Set("master_repl_offset", "0")
+	// The replication ID we had before becoming a master, if we were a
+	// replica, and the offset up to which replicas can continue with it.
+	Set("master_replid2", strings.Repeat("0", 40))
+	Set("second_repl_offset", "-1")
 }
 
-func generateID() string {
+// GenerateID returns a random 40 character ID, as used for replication IDs.
+func GenerateID() string {
 	letters := "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	b := make([]byte, 40)
 	for i := range b {
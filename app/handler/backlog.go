@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+// The replication backlog keeps the most recent part of the replication
+// stream, so that a replica that briefly lost its connection can pick up where
+// it left off (PSYNC CONTINUE), rather than be sent the whole dataset again.
+//
+// Offsets count the bytes of the replication stream since the replication ID
+// was created. The replication offset is that of the last byte written, so a
+// replica that has seen everything asks to continue from offset+1.
+type replBacklog struct {
+	mu  sync.Mutex
+	buf []byte
+	// Index in buf where the next byte is written.
+	next int
+	// Number of bytes of history held, up to len(buf).
+	histlen int
+	offset  int64
+}
+
+const defaultBacklogSize = 1 << 20
+
+var backlog = &replBacklog{}
+
+// write appends `p` to the backlog, advancing the replication offset.
+func (bl *replBacklog) write(p []byte) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if bl.buf == nil {
+		size := defaultBacklogSize
+		if s, _ := config.Get("repl-backlog-size"); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 {
+				size = n
+			} else {
+				log.Printf("[Backlog] Invalid repl-backlog-size %q, using %d\n", s, size)
+			}
+		}
+		bl.buf = make([]byte, size)
+	}
+	bl.offset += int64(len(p))
+	// Only the tail of a write larger than the backlog is kept.
+	if len(p) > len(bl.buf) {
+		p = p[len(p)-len(bl.buf):]
+	}
+	for len(p) > 0 {
+		n := copy(bl.buf[bl.next:], p)
+		bl.next = (bl.next + n) % len(bl.buf)
+		p = p[n:]
+		bl.histlen = min(bl.histlen+n, len(bl.buf))
+	}
+	config.Set("master_repl_offset", strconv.FormatInt(bl.offset, 10))
+}
+
+// since returns the stream from `offset` onwards, or false if the backlog
+// doesn't reach back that far (or forward; offset may be one past the end).
+func (bl *replBacklog) since(offset int64) ([]byte, bool) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	first := bl.offset - int64(bl.histlen) + 1
+	if offset < first || offset > bl.offset+1 {
+		return nil, false
+	}
+	n := int(bl.offset - offset + 1)
+	out := make([]byte, 0, n)
+	if n == 0 {
+		return out, true
+	}
+	start := (bl.next - n + len(bl.buf)) % len(bl.buf)
+	if start+n <= len(bl.buf) {
+		return append(out, bl.buf[start:start+n]...), true
+	}
+	out = append(out, bl.buf[start:]...)
+	return append(out, bl.buf[:n-(len(bl.buf)-start)]...), true
+}
+
+// getOffset returns the replication offset.
+func (bl *replBacklog) getOffset() int64 {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	return bl.offset
+}
+
+// reset drops the backlog's history, and sets the replication offset. Used
+// when the stream starts over, e.g. from a full resync.
+func (bl *replBacklog) reset(offset int64) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.next, bl.histlen, bl.offset = 0, 0, offset
+	config.Set("master_repl_offset", strconv.FormatInt(offset, 10))
+}
@@ -0,0 +1,43 @@
+package handler
+
+import "testing"
+
+func TestReplBacklogSince(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		// Offset the backlog is reset to before the writes, if not 0.
+		reset  int64
+		offset int64
+		want   string
+		wantOK bool
+	}{
+		{"empty", nil, 0, 1, "", true},
+		{"empty, before the start", nil, 0, 0, "", false},
+		{"empty, past the end", nil, 0, 2, "", false},
+		{"everything", []string{"abc", "de"}, 0, 1, "abcde", true},
+		{"from the middle", []string{"abc", "de"}, 0, 3, "cde", true},
+		{"one past the end", []string{"abc", "de"}, 0, 6, "", true},
+		{"two past the end", []string{"abc", "de"}, 0, 7, "", false},
+		{"wrapped around", []string{"abcdef", "ghij"}, 0, 3, "cdefghij", true},
+		{"wrapped around, overwritten", []string{"abcdef", "ghij"}, 0, 2, "", false},
+		{"wrapped around, after the wrap", []string{"abcdef", "ghij"}, 0, 10, "j", true},
+		{"write larger than the buffer", []string{"abc", "0123456789"}, 0, 6, "23456789", true},
+		{"write larger than the buffer, overwritten", []string{"abc", "0123456789"}, 0, 5, "", false},
+		{"after a reset", []string{"xy"}, 100, 101, "xy", true},
+		{"after a reset, before the start", []string{"xy"}, 100, 100, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bl := &replBacklog{buf: make([]byte, 8)}
+			bl.reset(tt.reset)
+			for _, w := range tt.writes {
+				bl.write([]byte(w))
+			}
+			got, ok := bl.since(tt.offset)
+			if ok != tt.wantOK || string(got) != tt.want {
+				t.Errorf("since(%d) = %q, %v, want %q, %v", tt.offset, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
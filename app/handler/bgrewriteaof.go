@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+)
+
+type BGRewriteAOFHandler = Handler
+
+// BGREWRITEAOF
+// Instruct Redis to start an Append Only File rewrite process. The rewrite
+// will create a small optimized version of the current Append Only File.
+func newBGRewriteAOFHandler(ctx *Ctx) BGRewriteAOFHandler {
+	args := ctx.GetArgs()
+	return &bgrewriteaofHandler{baseHandler{args: args}}
+}
+
+type bgrewriteaofHandler struct {
+	baseHandler
+}
+
+func (b *bgrewriteaofHandler) execute() CommandResponse {
+	// BGREWRITEAOF expects no arguments
+	if !b.argsExactly(0) {
+		return b.fmtErr("wrong number of arguments for command")
+	}
+	// BGREWRITEAOF is an exclusive command, so no writes can sneak in between
+	// the snapshot and the switch to a new incremental file; see Handle.
+	if err := persistence.BGRewriteAOF(); err != nil {
+		log.Println("[BGRewriteAOFHandler] Error starting rewrite: ", err)
+		return b.fmtErr(err.Error())
+	}
+	return b.fmtSimpleString("Background append only file rewriting started")
+}
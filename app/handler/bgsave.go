@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+)
+
+type BGSaveHandler = Handler
+
+// BGSAVE
+// Save the DB in background. The snapshot is taken immediately and written
+// out while the server keeps serving clients; use LASTSAVE to check whether
+// it succeeded.
+func newBGSaveHandler(ctx *Ctx) BGSaveHandler {
+	args := ctx.GetArgs()
+	return &bgsaveHandler{baseHandler{args: args}}
+}
+
+type bgsaveHandler struct {
+	baseHandler
+}
+
+func (b *bgsaveHandler) execute() CommandResponse {
+	// BGSAVE expects no arguments
+	if !b.argsExactly(0) {
+		return b.fmtErr("wrong number of arguments for command")
+	}
+	if err := persistence.BGSave(); err != nil {
+		if err == persistence.ErrSaveInProgress {
+			return b.fmtErr(err.Error())
+		}
+		log.Println("[BGSaveHandler] Error starting background save: ", err)
+		return b.fmtErr("Unexpected server error")
+	}
+	return b.fmtSimpleString("Background saving started")
+}
@@ -4,4 +4,7 @@ package handler
 type Client interface {
 	Init() error
 	Handle()
+	// Run connects, and keeps reconnecting, until the server shuts down.
+	Run()
+	Stop()
 }
@@ -9,6 +9,13 @@ type Ctx struct {
 	clientAddr string
 	cmd        string
 	conn       net.Conn
+	// Set for commands replicated from our master, to the client that
+	// received them.
+	master *replicationClient
+	// The command as received from our master, which is forwarded as is.
+	raw []byte
+	// Set for commands replayed from the AOF as it's loaded.
+	replayed bool
 }
 
 // Getters
@@ -46,3 +53,9 @@ func (c *Ctx) SetClientAddr(clientAddr string) {
 func (c *Ctx) SetConn(conn net.Conn) {
 	c.conn = conn
 }
+
+// SetReplayed marks the command as replayed from the AOF, so it's applied
+// without being replicated or logged again.
+func (c *Ctx) SetReplayed() {
+	c.replayed = true
+}
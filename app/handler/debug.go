@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+)
+
+type DebugHandler = Handler
+
+// DEBUG subcommand [argument ...]
+// The DEBUG command is an internal command, meant for developing and testing
+// Redis. It's only allowed when the enable-debug-command config is "yes", or
+// "local" and the client is connected over loopback.
+func newDebugHandler(ctx *Ctx) DebugHandler {
+	args := ctx.GetArgs()
+	return &debugHandler{isLocalConn(ctx.GetConn()), baseHandler{args: args}}
+}
+
+type debugHandler struct {
+	local bool
+	baseHandler
+}
+
+// isLocalConn returns true if `conn` is from the loopback interface. Commands
+// without a connection (replayed from the AOF) count as local.
+func isLocalConn(conn net.Conn) bool {
+	if conn == nil {
+		return true
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return !ok || addr.IP.IsLoopback()
+}
+
+var debugHelp = []string{
+	"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"JMAP",
+	"    Show memory and keyspace diagnostics.",
+	"OBJECT <key>",
+	"    Show low level info about the key and associated value.",
+	"RELOAD [NOSAVE]",
+	"    Save the RDB on disk and reload it back to memory. With NOSAVE, load the",
+	"    existing RDB file without saving first.",
+	"SET-ACTIVE-EXPIRE <0|1>",
+	"    Setting it to 0 disables expiring keys in background when they are not",
+	"    accessed (otherwise the Redis behavior). Setting it to 1 reenables back",
+	"    the default.",
+	"SLEEP <seconds>",
+	"    Stop the server for <seconds>. Decimals allowed.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (d *debugHandler) execute() CommandResponse {
+	switch mode, _ := config.Get("enable-debug-command"); {
+	case mode == "yes", mode == "local" && d.local:
+	default:
+		return d.fmtErr("DEBUG command not allowed. If the enable-debug-command option is set to \"local\", you can run it from a local connection, otherwise you need to set this option and restart the server.")
+	}
+	// DEBUG expects at least one argument
+	if !d.argsAtLeast(1) {
+		return d.fmtErr("wrong number of arguments for command")
+	}
+	args := make([]string, 0, len(d.args))
+	for _, arg := range d.args {
+		s, ok := arg.(string)
+		if !ok {
+			return d.fmtErr("syntax error")
+		}
+		args = append(args, s)
+	}
+	sub, args := strings.ToUpper(args[0]), args[1:]
+	switch {
+	case sub == "HELP" && len(args) == 0:
+		resp := d.fmtArrayLen(len(debugHelp))
+		for _, line := range debugHelp {
+			resp = append(resp, d.fmtSimpleString(line)...)
+		}
+		return resp
+	case sub == "RELOAD":
+		return d.reload(args)
+	case sub == "OBJECT" && len(args) == 1:
+		return d.object(args[0])
+	case sub == "SLEEP" && len(args) == 1:
+		secs, err := strconv.ParseFloat(args[0], 64)
+		if err != nil || secs < 0 {
+			return d.fmtErr("value is not a valid float")
+		}
+		// DEBUG holds off every other command while it runs, so this stops
+		// the server.
+		time.Sleep(time.Duration(secs * float64(time.Second)))
+		return d.fmtSimpleString("OK")
+	case sub == "SET-ACTIVE-EXPIRE" && len(args) == 1:
+		enabled, err := strconv.Atoi(args[0])
+		if err != nil || (enabled != 0 && enabled != 1) {
+			return d.fmtErr("value is out of range")
+		}
+		cache.GetDefaultCache().SetActiveExpire(enabled == 1)
+		return d.fmtSimpleString("OK")
+	case sub == "JMAP" && len(args) == 0:
+		return d.fmtBulkString(d.jmap())
+	default:
+		return d.fmtErr(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try DEBUG HELP.", sub))
+	}
+}
+
+// reload saves the dataset, then loads it back from the RDB file.
+func (d *debugHandler) reload(args []string) CommandResponse {
+	save := true
+	for _, arg := range args {
+		if strings.ToUpper(arg) != "NOSAVE" {
+			return d.fmtErr("DEBUG RELOAD only supports the NOSAVE option.")
+		}
+		save = false
+	}
+	if save {
+		if err := persistence.Save(); err != nil {
+			log.Println("[DebugHandler] Error saving: ", err)
+			return d.fmtErr("Error trying to save the DB")
+		}
+	}
+	if err := persistence.Reload(); err != nil {
+		log.Println("[DebugHandler] Error reloading: ", err)
+		return d.fmtErr("Error trying to load the RDB dump, check server logs.")
+	}
+	log.Println("[DebugHandler] DB reloaded by DEBUG RELOAD")
+	return d.fmtSimpleString("OK")
+}
+
+// object describes how `key` is stored.
+func (d *debugHandler) object(key string) CommandResponse {
+	info, ok := cache.GetDefaultCache().Object(key)
+	if !ok {
+		return d.fmtErr("no such key")
+	}
+	compression, _ := config.Get("rdbcompression")
+	size, err := parser.SerializedLength(info.RDBEntry().Value, compression == "yes")
+	if err != nil {
+		log.Println("[DebugHandler] Error serializing value: ", err)
+		return d.fmtErr("Unexpected server error")
+	}
+	// The LRU clock counts seconds, in 24 bits.
+	idle := int64(info.Idle / time.Second)
+	lru := (time.Now().Unix() - idle) & (1<<24 - 1)
+	return d.fmtSimpleString(fmt.Sprintf(
+		"refcount:1 encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d lfu_freq:%d",
+		info.Encoding, size, lru, idle, info.Freq,
+	))
+}
+
+// jmap reports runtime memory statistics and a summary of the keyspace.
+func (d *debugHandler) jmap() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	keys, expires, types := cache.GetDefaultCache().KeyspaceStats()
+	lines := []string{
+		"# Memory",
+		fmt.Sprintf("heap_alloc:%d", m.HeapAlloc),
+		fmt.Sprintf("heap_inuse:%d", m.HeapInuse),
+		fmt.Sprintf("heap_idle:%d", m.HeapIdle),
+		fmt.Sprintf("heap_objects:%d", m.HeapObjects),
+		fmt.Sprintf("stack_inuse:%d", m.StackInuse),
+		fmt.Sprintf("sys:%d", m.Sys),
+		fmt.Sprintf("num_gc:%d", m.NumGC),
+		fmt.Sprintf("gc_pause_total_ns:%d", m.PauseTotalNs),
+		fmt.Sprintf("goroutines:%d", runtime.NumGoroutine()),
+		"",
+		"# Keyspace",
+		fmt.Sprintf("keys:%d", keys),
+		fmt.Sprintf("expires:%d", expires),
+	}
+	names := make([]string, 0, len(types))
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		lines = append(lines, fmt.Sprintf("type_%s:%d", t, types[t]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+)
+
+type DelHandler = Handler
+
+// DEL key [key ...]
+// Removes the specified keys. A key is ignored if it does not exist.
+// Returns the number of keys that were removed.
+func newDelHandler(ctx *Ctx) DelHandler {
+	args := ctx.GetArgs()
+	return &delHandler{cache.GetDefaultCache(), baseHandler{args: args}}
+}
+
+type delHandler struct {
+	cache *cache.Cache
+	baseHandler
+}
+
+func (d *delHandler) execute() CommandResponse {
+	// DEL expects at least one argument
+	if !d.argsAtLeast(1) {
+		return d.fmtErr("wrong number of arguments for command")
+	}
+	keys := make([]string, 0, len(d.args))
+	for _, arg := range d.args {
+		key, ok := arg.(string)
+		if !ok {
+			log.Printf("[DelHandler] Non-string key: %#v\n", arg)
+			return d.fmtErr("syntax error")
+		}
+		keys = append(keys, key)
+	}
+	return d.fmtInteger(int64(d.cache.Delete(keys...)))
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+type DumpHandler = Handler
+
+// DUMP key
+// Serialize the value stored at key in a Redis-specific format and return it
+// to the user. The returned value can be synthesized back into a Redis key
+// using the RESTORE command.
+func newDumpHandler(ctx *Ctx) DumpHandler {
+	args := ctx.GetArgs()
+	return &dumpHandler{cache.GetDefaultCache(), baseHandler{args: args}}
+}
+
+type dumpHandler struct {
+	cache *cache.Cache
+	baseHandler
+}
+
+func (d *dumpHandler) execute() CommandResponse {
+	// DUMP expects exactly one argument
+	if !d.argsExactly(1) {
+		return d.fmtErr("wrong number of arguments for command")
+	}
+	key, ok := d.args[0].(string)
+	if !ok {
+		log.Printf("[DumpHandler] Non-string key: %#v\n", d.args[0])
+		return d.fmtErr("syntax error")
+	}
+	val, _, ok := d.cache.DumpValue(key)
+	if !ok {
+		return d.fmtNullString()
+	}
+	compression, _ := config.Get("rdbcompression")
+	payload, err := parser.EncodeDump(val, compression == "yes")
+	if err != nil {
+		log.Println("[DumpHandler] Error serializing value: ", err)
+		return d.fmtErr("Unexpected server error")
+	}
+	return d.fmtBulkString(string(payload))
+}
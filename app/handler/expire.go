@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+// RunActiveExpire runs active expiry cycles until the server shuts down.
+// Keys it deletes are propagated as DELs, so the AOF and replicas drop them
+// too. Replicas don't expire keys themselves, but wait for their master's DEL.
+func RunActiveExpire() {
+	ticker := time.NewTicker(cache.ActiveExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ShuttingDown():
+			return
+		case <-ticker.C:
+		}
+		activeExpireCycle()
+	}
+}
+
+func activeExpireCycle() {
+	// Held like a write, so that the DELs are in order with other writes.
+	execMu.Lock()
+	defer execMu.Unlock()
+	if replicaof, _ := config.Get("replicaof"); replicaof != "" {
+		return
+	}
+	for _, key := range cache.GetDefaultCache().ActiveExpireCycle() {
+		propagate([]string{"DEL", key})
+	}
+}
@@ -32,7 +32,10 @@ func (g *getHandler) execute() CommandResponse {
 		log.Printf("[GetHandler] Non-string key: %#v\n", g.args[0])
 		return g.fmtErr("syntax error")
 	}
-	val, ok := g.cache.Get(key)
+	val, ok, err := g.cache.Get(key)
+	if err == cache.ErrWrongType {
+		return g.fmtErrCode("WRONGTYPE", "Operation against a key holding the wrong kind of value")
+	}
 	if !ok {
 		return g.fmtNullString()
 	}
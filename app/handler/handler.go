@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
 )
 
 type Handler interface {
@@ -58,6 +62,19 @@ func (b *baseHandler) fmtErr(s string) CommandResponse {
 	return CommandResponse{[]byte(fmt.Sprintf("-ERR %s\r\n", s))}
 }
 
+// fmtErrCode formats `s` as a simple error, with the error code `code` in
+// place of ERR.
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#simple-errors
+func (b *baseHandler) fmtErrCode(code, s string) CommandResponse {
+	return CommandResponse{[]byte(fmt.Sprintf("-%s %s\r\n", code, s))}
+}
+
+// fmtInteger formats `i` as an integer.
+// https://redis.io/docs/latest/develop/reference/protocol-spec/#integers
+func (b *baseHandler) fmtInteger(i int64) CommandResponse {
+	return CommandResponse{[]byte(fmt.Sprintf(":%d\r\n", i))}
+}
+
 // fmtSimpleString formats `s` as a simple string.
 // https://redis.io/docs/latest/develop/reference/protocol-spec/#simple-strings
 func (b *baseHandler) fmtSimpleString(s string) CommandResponse {
@@ -86,18 +103,35 @@ func (d *defaultHandler) execute() CommandResponse {
 type HandlerFunc = func(*Ctx) Handler
 
 var handlers = map[string]HandlerFunc{
-	"CONFIG":   newConfigHandler,
-	"ECHO":     newEchoHandler,
-	"GET":      newGetHandler,
-	"INFO":     newInfoHandler,
-	"KEYS":     newKeysHander,
-	"PING":     newPingHandler,
-	"SET":      newSetHandler,
-	"PSYNC":    newPsyncHandler,
-	"REPLCONF": newReplconfHandler,
+	"BGREWRITEAOF": newBGRewriteAOFHandler,
+	"BGSAVE":       newBGSaveHandler,
+	"CONFIG":       newConfigHandler,
+	"DEBUG":        newDebugHandler,
+	"DEL":          newDelHandler,
+	"DUMP":         newDumpHandler,
+	"ECHO":         newEchoHandler,
+	"GET":          newGetHandler,
+	"INFO":         newInfoHandler,
+	"KEYS":         newKeysHander,
+	"LASTSAVE":     newLastSaveHandler,
+	"MIGRATE":      newMigrateHandler,
+	"PING":         newPingHandler,
+	"REPLICAOF":    newReplicaofHandler,
+	"SAVE":         newSaveHandler,
+	"SET":          newSetHandler,
+	"TYPE":         newTypeHandler,
+	"WAIT":         newWaitHandler,
+	"PSYNC":        newPsyncHandler,
+	"RESTORE":      newRestoreHandler,
+	"REPLCONF":     newReplconfHandler,
+	"SHUTDOWN":     newShutdownHandler,
+	"SLAVEOF":      newReplicaofHandler,
 }
 
 var replicatingCmds = []string{
+	"DEL",
+	"MIGRATE",
+	"RESTORE",
 	"SET",
 }
 
@@ -110,21 +144,152 @@ func isReplicatingCmd(cmd string) bool {
 	return false
 }
 
+// Exclusive commands wait for every in-flight command to finish, and hold off
+// any others until they're done. Replicating (write) commands are also run
+// exclusively, so that they're logged in the order they're applied.
+var exclusiveCmds = []string{
+	"BGREWRITEAOF",
+	"DEBUG",
+	"REPLICAOF",
+	"SHUTDOWN",
+	"SLAVEOF",
+	// Adds a GETACK to the replication stream.
+	"WAIT",
+}
+
+func isExclusiveCmd(cmd string) bool {
+	for _, c := range exclusiveCmds {
+		if c == cmd {
+			return true
+		}
+	}
+	return isReplicatingCmd(cmd)
+}
+
+// Held for reading by every command, or for writing by exclusive ones.
+var execMu sync.RWMutex
+
 // Main command handler
 func Handle(ctx *Ctx) CommandResponse {
+	resp, wait := HandleAsync(ctx)
+	if wait != nil {
+		return wait()
+	}
+	return resp
+}
+
+// HandleAsync runs the command in `ctx`, unless it has to wait on other
+// clients (as WAIT does). In that case it returns a function that waits, and
+// returns the response; callers that run commands one at a time should call
+// it without holding up the commands that follow.
+func HandleAsync(ctx *Ctx) (CommandResponse, func() CommandResponse) {
 	cmd := ctx.GetCmd()
-	handler, ok := handlers[strings.ToUpper(fmt.Sprint(cmd))]
+	name := strings.ToUpper(fmt.Sprint(cmd))
+	// Commands from our master are run exclusively too, as they all advance
+	// the replication offset.
+	if isExclusiveCmd(name) || ctx.master != nil {
+		execMu.Lock()
+		defer execMu.Unlock()
+	} else {
+		execMu.RLock()
+		defer execMu.RUnlock()
+	}
+	if ctx.master != nil {
+		// REPLICAOF may have switched masters since the command was read.
+		if ctx.master != currentMaster {
+			return nil, nil
+		}
+		// Keep our backlog, and so our offset, in step with the master's
+		// stream, and forward the stream to our own replicas, once the
+		// command's been applied. The bytes are passed on as received.
+		defer func() {
+			backlog.write(ctx.raw)
+			notifyReplicas(ctx.raw)
+		}()
+	}
+	handler, ok := handlers[name]
 	if !ok {
 		log.Printf("[Handle] Unexpected command: %q\n", cmd)
-		return newDefaultHandler(ctx).execute()
+		return newDefaultHandler(ctx).execute(), nil
+	}
+	if shuttingDown {
+		return b.fmtErr("server is shutting down"), nil
+	}
+	// Writes from clients may be refused. Writes from our master, or replayed
+	// from the AOF, don't come from a client connection.
+	if isReplicatingCmd(name) && ctx.GetConn() != nil && ctx.master == nil {
+		if isReadOnlyReplica() {
+			return b.fmtErrCode("READONLY", "You can't write against a read only replica."), nil
+		}
+		if !enoughGoodReplicas() {
+			return b.fmtErrCode("NOREPLICAS", "Not enough good replicas to write."), nil
+		}
 	}
-	if isReplicatingCmd(cmd) {
-		command := []string{cmd}
-		args := ctx.GetArgs()
-		for _, a := range args {
+	h := handler(ctx)
+	resp := h.execute()
+	if bl, ok := h.(blocker); ok && resp == nil {
+		return nil, bl.block
+	}
+	// Commands replayed from the AOF are already logged, and replicas sync
+	// from the loaded dataset.
+	if !isReplicatingCmd(name) || ctx.replayed {
+		return resp, nil
+	}
+
+	var command []string
+	if p, ok := h.(propagator); ok {
+		command = p.propagate()
+	} else if !isErrResponse(resp) {
+		command = []string{cmd}
+		for _, a := range ctx.GetArgs() {
 			command = append(command, fmt.Sprint(a))
 		}
-		go notifyReplicas(command)
 	}
-	return handler(ctx).execute()
+	if len(command) == 0 {
+		return resp, nil
+	}
+	// The stream from our own master is added to the backlog, and forwarded,
+	// as received.
+	if ctx.master != nil {
+		persistence.AppendCommand(command)
+		return resp, nil
+	}
+	propagate(command)
+	return resp, nil
+}
+
+// propagate adds a write of our own to the replication stream, and logs it to
+// the AOF. execMu must be held exclusively, so that the stream's in order.
+func propagate(command []string) {
+	encoded := encodeCommand(command)
+	backlog.write(encoded)
+	notifyReplicas(encoded)
+	persistence.AppendCommand(command)
+}
+
+// isReadOnlyReplica returns true if we're a replica that doesn't take writes
+// from clients.
+func isReadOnlyReplica() bool {
+	replicaof, _ := config.Get("replicaof")
+	readOnly, _ := config.Get("replica-read-only")
+	return replicaof != "" && readOnly == "yes"
+}
+
+// A blocker is a command that may have to wait on other clients. If `execute`
+// returns nil, `block` is called once other commands are free to run, to wait
+// and return the response.
+type blocker interface {
+	block() CommandResponse
+}
+
+// A propagator is a replicating command whose effects are logged and sent to
+// replicas as some other command, rather than as itself. `propagate` is called
+// after `execute`, and returns nil if there's nothing to propagate.
+type propagator interface {
+	propagate() []string
+}
+
+// isErrResponse returns true if `resp` is an error reply.
+func isErrResponse(resp CommandResponse) bool {
+	return len(resp) > 0 && len(resp[0]) > 0 && resp[0][0] == '-'
 }
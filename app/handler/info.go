@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
 	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
 )
 
 type InfoHandler = Handler
@@ -23,7 +26,7 @@ type infoHandler struct {
 }
 
 func (i *infoHandler) execute() CommandResponse {
-	sections := []string{"replication"}
+	sections := []string{"persistence", "replication"}
 	responseLines := []string{}
 
 	if len(i.args) == 0 {
@@ -40,6 +43,26 @@ func (i *infoHandler) execute() CommandResponse {
 			return i.fmtErr("syntax error")
 		}
 		switch strings.ToLower(section) {
+		case "persistence":
+			responseLines = append(responseLines, "# Persistence")
+			status := persistence.GetStatus()
+			dirty := cache.GetDefaultCache().Dirty()
+			responseLines = append(responseLines, fmt.Sprintf("rdb_changes_since_last_save:%d", dirty))
+			responseLines = append(responseLines, fmt.Sprintf("rdb_bgsave_in_progress:%d", boolToInt(status.BGSaveInProgress)))
+			responseLines = append(responseLines, fmt.Sprintf("rdb_last_save_time:%d", status.LastSave.Unix()))
+			bgsaveStatus := "ok"
+			if !status.LastBGSaveOK {
+				bgsaveStatus = "err"
+			}
+			responseLines = append(responseLines, fmt.Sprintf("rdb_last_bgsave_status:%s", bgsaveStatus))
+			aofStatus := persistence.GetAOFStatus()
+			responseLines = append(responseLines, fmt.Sprintf("aof_enabled:%d", boolToInt(aofStatus.Enabled)))
+			responseLines = append(responseLines, fmt.Sprintf("aof_rewrite_in_progress:%d", boolToInt(aofStatus.RewriteInProgress)))
+			bgrewriteStatus := "ok"
+			if !aofStatus.LastBGRewriteOK {
+				bgrewriteStatus = "err"
+			}
+			responseLines = append(responseLines, fmt.Sprintf("aof_last_bgrewrite_status:%s", bgrewriteStatus))
 		case "replication":
 			responseLines = append(responseLines, "# Replication")
 			// Get replica status from config
@@ -50,14 +73,42 @@ func (i *infoHandler) execute() CommandResponse {
 			}
 			if replicaof == "" {
 				responseLines = append(responseLines, "role:master")
-				// Ignore config 'misses' here
-				replid, _ := config.Get("master_replid")
-				responseLines = append(responseLines, fmt.Sprintf("master_replid:%s", replid))
-				replOffset, _ := config.Get("master_repl_offset")
-				responseLines = append(responseLines, fmt.Sprintf("master_repl_offset:%s", replOffset))
+				online, good := countGoodReplicas()
+				responseLines = append(responseLines, fmt.Sprintf("connected_slaves:%d", online))
+				if configInt("min-replicas-to-write", 0, 0) > 0 {
+					responseLines = append(responseLines, fmt.Sprintf("min_slaves_good_slaves:%d", good))
+				}
 			} else {
 				responseLines = append(responseLines, "role:slave")
+				host, port, _ := strings.Cut(replicaof, " ")
+				responseLines = append(responseLines, fmt.Sprintf("master_host:%s", host))
+				responseLines = append(responseLines, fmt.Sprintf("master_port:%s", port))
+				up, syncing, lastIO, downSince := masterLinkInfo()
+				linkStatus, lastIOAgo := "down", int64(-1)
+				if up {
+					linkStatus = "up"
+					lastIOAgo = int64(time.Since(lastIO) / time.Second)
+				}
+				responseLines = append(responseLines, fmt.Sprintf("master_link_status:%s", linkStatus))
+				responseLines = append(responseLines, fmt.Sprintf("master_last_io_seconds_ago:%d", lastIOAgo))
+				responseLines = append(responseLines, fmt.Sprintf("master_sync_in_progress:%d", boolToInt(syncing)))
+				if !up {
+					downSinceAgo := int64(-1)
+					if !downSince.IsZero() {
+						downSinceAgo = int64(time.Since(downSince) / time.Second)
+					}
+					responseLines = append(responseLines, fmt.Sprintf("master_link_down_since_seconds:%d", downSinceAgo))
+				}
 			}
+			// Ignore config 'misses' here
+			replid, _ := config.Get("master_replid")
+			responseLines = append(responseLines, fmt.Sprintf("master_replid:%s", replid))
+			replid2, _ := config.Get("master_replid2")
+			responseLines = append(responseLines, fmt.Sprintf("master_replid2:%s", replid2))
+			replOffset, _ := config.Get("master_repl_offset")
+			responseLines = append(responseLines, fmt.Sprintf("master_repl_offset:%s", replOffset))
+			secondOffset, _ := config.Get("second_repl_offset")
+			responseLines = append(responseLines, fmt.Sprintf("second_repl_offset:%s", secondOffset))
 		default:
 			// Ignore unrecognized section
 			log.Printf("[InfoHandler] Unrecognized INFO section %q\n", section)
@@ -69,3 +120,10 @@ func (i *infoHandler) execute() CommandResponse {
 	}
 	return i.fmtBulkString(resp)
 }
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package handler
+
+import "github.com/codecrafters-io/redis-starter-go/app/persistence"
+
+type LastSaveHandler = Handler
+
+// LASTSAVE
+// Return the UNIX TIME of the last DB save executed with success.
+func newLastSaveHandler(ctx *Ctx) LastSaveHandler {
+	args := ctx.GetArgs()
+	return &lastSaveHandler{baseHandler{args: args}}
+}
+
+type lastSaveHandler struct {
+	baseHandler
+}
+
+func (l *lastSaveHandler) execute() CommandResponse {
+	// LASTSAVE expects no arguments
+	if !l.argsExactly(0) {
+		return l.fmtErr("wrong number of arguments for command")
+	}
+	return l.fmtInteger(persistence.GetStatus().LastSave.Unix())
+}
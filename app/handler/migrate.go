@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+type MigrateHandler = Handler
+
+// MIGRATE host port <key | ""> destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+//
+// Atomically transfer keys from this instance to a destination instance. On
+// success the keys are deleted from this instance.
+// Options:
+// * timeout -- Maximum idle time in milliseconds talking to the destination.
+// * COPY -- Do not remove the keys from this instance.
+// * REPLACE -- Replace existing keys on the destination instance.
+// * AUTH password -- Authenticate with the given password.
+// * AUTH2 username password -- Authenticate with the given username and password.
+// * KEYS key [key ...] -- Migrate several keys; the key argument must be "".
+func newMigrateHandler(ctx *Ctx) MigrateHandler {
+	args := ctx.GetArgs()
+	return &migrateHandler{cache.GetDefaultCache(), nil, baseHandler{args: args}}
+}
+
+type migrateHandler struct {
+	cache *cache.Cache
+	// Keys deleted after being migrated.
+	deleted []string
+	baseHandler
+}
+
+func (m *migrateHandler) execute() CommandResponse {
+	// MIGRATE expects at least five arguments
+	if !m.argsAtLeast(5) {
+		return m.fmtErr("wrong number of arguments for command")
+	}
+	args := make([]string, 0, len(m.args))
+	for _, arg := range m.args {
+		s, ok := arg.(string)
+		if !ok {
+			return m.fmtErr("syntax error")
+		}
+		args = append(args, s)
+	}
+	host, port, keys := args[0], args[1], []string{args[2]}
+	db, err := strconv.Atoi(args[3])
+	if err != nil || db < 0 {
+		return m.fmtErr("value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return m.fmtErr("value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	// Parse options
+	var (
+		copyKeys, replace bool
+		auth              []string
+	)
+	options := args[5:]
+	for len(options) > 0 {
+		opt, rest := strings.ToUpper(options[0]), options[1:]
+		switch {
+		case opt == "COPY":
+			copyKeys = true
+		case opt == "REPLACE":
+			replace = true
+		case opt == "AUTH" && len(rest) > 0:
+			auth = []string{"AUTH", rest[0]}
+			rest = rest[1:]
+		case opt == "AUTH2" && len(rest) > 1:
+			auth = []string{"AUTH", rest[0], rest[1]}
+			rest = rest[2:]
+		case opt == "KEYS":
+			if keys[0] != "" {
+				return m.fmtErr("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys, rest = rest, nil
+		default:
+			return m.fmtErr("syntax error")
+		}
+		// Set options for next loop
+		options = rest
+	}
+
+	// Serialize the keys that exist.
+	compression, _ := config.Get("rdbcompression")
+	var migrating []string
+	var restores [][]string
+	for _, key := range keys {
+		val, exp, ok := m.cache.DumpValue(key)
+		if !ok {
+			continue
+		}
+		payload, err := parser.EncodeDump(val, compression == "yes")
+		if err != nil {
+			log.Println("[MigrateHandler] Error serializing value: ", err)
+			return m.fmtErr("Unexpected server error")
+		}
+		// The target logs and replicates the TTL as an absolute time.
+		var ttl int64
+		if !exp.IsZero() {
+			ttl = max(time.Until(exp).Milliseconds(), 1)
+		}
+		restore := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		migrating = append(migrating, key)
+		restores = append(restores, restore)
+	}
+	if len(migrating) == 0 {
+		return m.fmtSimpleString("NOKEY")
+	}
+
+	addr := net.JoinHostPort(host, port)
+	wait := time.Duration(timeout) * time.Millisecond
+	replies, err := m.transfer(addr, db, auth, restores, wait)
+	var setupErr *migrateSetupError
+	if errors.As(err, &setupErr) {
+		// AUTH or SELECT failed, so none of the keys were restored.
+		return m.fmtErr("Target instance replied with error: " + setupErr.Error())
+	}
+	if err != nil {
+		log.Printf("[MigrateHandler] Error migrating to %s: %v\n", addr, err)
+		return m.fmtErrCode("IOERR", "error or timeout reading to target instance")
+	}
+
+	var restoreErr error
+	for i, reply := range replies {
+		if reply != nil {
+			if restoreErr == nil {
+				restoreErr = reply
+			}
+			continue
+		}
+		if !copyKeys {
+			m.deleted = append(m.deleted, migrating[i])
+		}
+	}
+	m.cache.Delete(m.deleted...)
+	if restoreErr != nil {
+		return m.fmtErr("Target instance replied with error: " + restoreErr.Error())
+	}
+	return m.fmtSimpleString("OK")
+}
+
+// Migrated keys are deleted on replicas and in the AOF, rather than migrated
+// again from there.
+func (m *migrateHandler) propagate() []string {
+	if len(m.deleted) == 0 {
+		return nil
+	}
+	return append([]string{"DEL"}, m.deleted...)
+}
+
+// transfer sends `restores` to the instance at `addr`, after authenticating
+// and selecting `db` if needed, and returns the error reply (or nil) for each
+// RESTORE. If AUTH or SELECT fails, a *migrateSetupError is returned instead.
+//
+// A connection taken from the cache may have been closed by the other side
+// since it was last used, so it's retried once with a new connection, unless
+// it timed out.
+func (m *migrateHandler) transfer(addr string, db int, auth []string, restores [][]string, timeout time.Duration) ([]error, error) {
+	mc, cached, err := getMigrateConn(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := mc.transfer(db, auth, restores, timeout)
+	var setupErr *migrateSetupError
+	if err != nil && cached && !isTimeout(err) && !errors.As(err, &setupErr) {
+		closeMigrateConn(addr)
+		if mc, _, err = getMigrateConn(addr, timeout); err != nil {
+			return nil, err
+		}
+		replies, err = mc.transfer(db, auth, restores, timeout)
+	}
+	if err != nil {
+		closeMigrateConn(addr)
+		return nil, err
+	}
+	mc.release(addr)
+	return replies, nil
+}
+
+var (
+	errMigrateRead    = errors.New("error reading from target instance")
+	errMigrateTimeout = errors.New("timeout reading from target instance")
+)
+
+// migrateSetupError is an error reply to AUTH or SELECT.
+type migrateSetupError struct {
+	parser.RESPError
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return err == errMigrateTimeout || ok && netErr.Timeout()
+}
+
+// Connections to MIGRATE targets are kept open between commands, keyed by
+// "host:port", and closed once they've gone unused for migrateConnIdle.
+type migrateConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// The database last selected on the connection.
+	db    int
+	timer *time.Timer
+	baseHandler
+}
+
+const migrateConnIdle = 10 * time.Second
+
+var (
+	migrateConnsMu sync.Mutex
+	migrateConns   = map[string]*migrateConn{}
+)
+
+// getMigrateConn returns the cached connection to `addr`, or opens a new one.
+// Returns true if the connection was cached. Pass the connection to release
+// once done with it.
+func getMigrateConn(addr string, timeout time.Duration) (*migrateConn, bool, error) {
+	migrateConnsMu.Lock()
+	defer migrateConnsMu.Unlock()
+	if mc, ok := migrateConns[addr]; ok {
+		if mc.timer.Stop() {
+			return mc, true, nil
+		}
+		// The idle timer has already fired, and is waiting to close it.
+		delete(migrateConns, addr)
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	log.Printf("[MigrateHandler] Connected to %s\n", addr)
+	mc := &migrateConn{conn: conn, reader: bufio.NewReader(conn)}
+	migrateConns[addr] = mc
+	return mc, false, nil
+}
+
+// release starts the connection's idle timer.
+func (mc *migrateConn) release(addr string) {
+	if mc.timer != nil {
+		mc.timer.Reset(migrateConnIdle)
+		return
+	}
+	mc.timer = time.AfterFunc(migrateConnIdle, func() {
+		log.Printf("[MigrateHandler] Closing idle connection to %s\n", addr)
+		migrateConnsMu.Lock()
+		defer migrateConnsMu.Unlock()
+		mc.conn.Close()
+		if migrateConns[addr] == mc {
+			delete(migrateConns, addr)
+		}
+	})
+}
+
+// closeMigrateConn closes and forgets the cached connection to `addr`.
+func closeMigrateConn(addr string) {
+	migrateConnsMu.Lock()
+	defer migrateConnsMu.Unlock()
+	if mc, ok := migrateConns[addr]; ok {
+		if mc.timer != nil {
+			mc.timer.Stop()
+		}
+		mc.conn.Close()
+		delete(migrateConns, addr)
+	}
+}
+
+// transfer pipelines AUTH, SELECT, and `restores` on the connection, then
+// reads their replies. See migrateHandler.transfer.
+func (mc *migrateConn) transfer(db int, auth []string, restores [][]string, timeout time.Duration) ([]error, error) {
+	var cmds [][]string
+	if auth != nil {
+		cmds = append(cmds, auth)
+	}
+	// Servers start out on database 0, so it doesn't need selecting.
+	if db != mc.db {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(db)})
+	}
+	setup := len(cmds)
+	cmds = append(cmds, restores...)
+
+	req := []byte{}
+	for _, cmd := range cmds {
+		req = append(req, mc.fmtArrayLen(len(cmd))[0]...)
+		for _, str := range cmd {
+			req = append(req, mc.fmtBulkString(str)[0]...)
+		}
+	}
+	mc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := mc.conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	respParser := parser.NewRESPParser(mc.reader)
+	replies := make([]error, 0, len(restores))
+	for i := range cmds {
+		deadline := time.Now().Add(timeout)
+		mc.conn.SetReadDeadline(deadline)
+		reply := respParser.Parse()
+		if reply == nil {
+			if time.Now().After(deadline) {
+				return nil, errMigrateTimeout
+			}
+			return nil, errMigrateRead
+		}
+		respErr, isErr := reply.(parser.RESPError)
+		if i < setup {
+			if isErr {
+				// The rest of the replies are left unread, so the connection
+				// is closed rather than reused.
+				return nil, &migrateSetupError{respErr}
+			}
+			if cmds[i][0] == "SELECT" {
+				mc.db = db
+			}
+			continue
+		}
+		if isErr {
+			replies = append(replies, respErr)
+		} else {
+			replies = append(replies, nil)
+		}
+	}
+	return replies, nil
+}
@@ -1,63 +1,209 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"path/filepath"
+	"net"
+	"strconv"
+	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
 	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
 )
 
 type PsyncHandler = Handler
 
 // PSYNC replicationid offset
 // The PSYNC command is called by Redis replicas for initiating a replication
-// stream from the master. Just reply +FULLRESYNC <REPL_ID> 0\r\n for now.
+// stream from the master. Replicas accept it too, and pass on the stream from
+// their own master, under its replication ID. If the replica's replication ID is ours (or the one
+// we had before being promoted, up to the offset we were promoted at), and the
+// backlog still holds the stream from `offset` on, reply +CONTINUE and send
+// the rest of the stream; otherwise reply +FULLRESYNC <REPL_ID> <OFFSET> and
+// send a snapshot of the dataset as of OFFSET. Writes made while the snapshot
+// is being sent are queued, and follow it.
 func newPsyncHandler(ctx *Ctx) PsyncHandler {
 	args := ctx.GetArgs()
-	return &psyncHandler{baseHandler{args: args}}
+	return &psyncHandler{ctx.GetConn(), baseHandler{args: args}}
 }
 
 type psyncHandler struct {
+	conn net.Conn
 	baseHandler
 }
 
-// RDB file with no data, hex encoded.
-const emptyRDBhex = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-
 func (p *psyncHandler) execute() CommandResponse {
-	resp := make(CommandResponse, 2)
-	// Send FULLRESYNC cmd response
-	repl_id, _ := config.Get("master_replid")
-	resp = append(resp, p.fmtSimpleString(fmt.Sprintf("FULLRESYNC %s 0", repl_id))...)
-	// Send rdb file response
-	// Attempt to read configured RDB file; fallback to empty
-	dir, _ := config.Get("dir")
-	dbfilename, _ := config.Get("dbfilename")
-	dbfilepath := filepath.Join(dir, dbfilename)
-	dbFile, err := os.Open(dbfilepath)
-	var filebytes []byte
-	if os.IsNotExist(err) {
-		// Fallback
-		filebytes, err = hex.DecodeString(emptyRDBhex)
-		if err != nil {
-			log.Fatal("Error decoding empty RDB file: ", err.Error())
+	// PSYNC expects exactly two arguments
+	if !p.argsExactly(2) {
+		return p.fmtErr("wrong number of arguments for command")
+	}
+	replid, _ := p.args[0].(string)
+	offset, err := strconv.ParseInt(fmt.Sprint(p.args[1]), 10, 64)
+	if err != nil {
+		return p.fmtErr("value is not an integer or out of range")
+	}
+	if p.conn == nil {
+		return p.fmtErr("PSYNC requires a client connection")
+	}
+	// A replica passes on its master's stream, so it needs to be following
+	// it.
+	if replicaof, _ := config.Get("replicaof"); replicaof != "" {
+		if up, _, _, _ := masterLinkInfo(); !up {
+			return p.fmtErrCode("NOMASTERLINK", "Can't SYNC while not connected with my master")
 		}
-	} else if err != nil {
-		log.Printf("[PsyncHandler] Error opening file %s: %s\n", dbfilepath, err)
-		return p.fmtErr("Unexpected server error")
+	}
+	addr := p.conn.RemoteAddr().String()
+	// Capabilities the replica announced with REPLCONF capa.
+	capas := takeCapas(p.conn)
+
+	// Writes are held off while we run, so the dataset and the backlog are
+	// as of masterOffset, and the replica is registered before any more
+	// writes are made. Nothing's written to the replica until we're done.
+	masterReplid, _ := config.Get("master_replid")
+	masterOffset := backlog.getOffset()
+	if stream, ok := backlog.since(offset); ok && canContinue(replid, offset) {
+		log.Printf("[PsyncHandler] Partial resync from offset %d, sending %d bytes of backlog\n", offset, len(stream))
+		r := registerReplica(addr, p.conn, masterOffset)
+		// Queued ahead of any writes that follow.
+		resp := append(p.fmtSimpleString("CONTINUE " + masterReplid)[0], stream...)
+		if !r.enqueue(resp, replicaOutputLimit()) {
+			deregisterReplica(r, "output buffer limit reached")
+			return CommandResponse{}
+		}
+		r.start()
+		return CommandResponse{}
+	}
+
+	log.Printf("[PsyncHandler] Full resync of %s at offset %d\n", addr, masterOffset)
+	fullresync := p.fmtSimpleString(fmt.Sprintf("FULLRESYNC %s %d", masterReplid, masterOffset))[0]
+	entries, _ := cache.GetDefaultCache().Snapshot()
+	repl := persistence.ReplInfo{ID: masterReplid, Offset: masterOffset}
+	r := registerReplica(addr, p.conn, masterOffset)
+	diskless, _ := config.Get("repl-diskless-sync")
+	if diskless == "yes" && capas["eof"] {
+		go p.streamSnapshot(r, fullresync, entries, repl)
 	} else {
-		defer dbFile.Close()
-		filebytes, err = io.ReadAll(dbFile)
+		go p.sendSnapshot(r, fullresync, entries, repl)
+	}
+	return CommandResponse{}
+}
+
+// canContinue returns true if a replica that's followed the replication ID
+// `replid` can continue with our stream from `offset`.
+func canContinue(replid string, offset int64) bool {
+	if masterReplid, _ := config.Get("master_replid"); replid == masterReplid {
+		return true
+	}
+	replid2, _ := config.Get("master_replid2")
+	secondOffset, _ := config.Get("second_repl_offset")
+	n, err := strconv.ParseInt(secondOffset, 10, 64)
+	return err == nil && replid == replid2 && offset <= n
+}
+
+// streamSnapshot writes the `fullresync` reply to the replica, followed by
+// `entries` as an RDB file as they're encoded, then starts sending it the
+// replication stream. Since the size isn't known up front, the file is
+// delimited by a random mark.
+func (p *psyncHandler) streamSnapshot(r *replica, fullresync []byte, entries []cache.Entry, repl persistence.ReplInfo) {
+	markBytes := make([]byte, rdbEOFMarkLen/2)
+	rand.Read(markBytes)
+	mark := hex.EncodeToString(markBytes)
+	tw := newTransferWriter(p.conn)
+	cw := &countingWriter{Writer: tw}
+	w := bufio.NewWriterSize(cw, 64*1024)
+	w.Write(fullresync)
+	w.WriteString("$EOF:" + mark + "\r\n")
+	if err := persistence.WriteSnapshot(w, entries, repl); err != nil {
+		log.Println("[PsyncHandler] Error streaming snapshot: ", err)
+		deregisterReplica(r, err.Error())
+		return
+	}
+	w.WriteString(mark)
+	if err := w.Flush(); err != nil {
+		log.Println("[PsyncHandler] Error streaming snapshot: ", err)
+		deregisterReplica(r, err.Error())
+		return
+	}
+	log.Printf("[PsyncHandler] Streamed %d byte snapshot to %s\n", cw.n-int64(len(fullresync)), r.addr)
+	tw.done()
+	r.start()
+}
+
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sendSnapshot sends the `fullresync` reply to the replica, followed by
+// `entries` as an RDB file prefixed with its size, then starts sending it the
+// replication stream. The file is built in memory first.
+func (p *psyncHandler) sendSnapshot(r *replica, fullresync []byte, entries []cache.Entry, repl persistence.ReplInfo) {
+	var rdb bytes.Buffer
+	if err := persistence.WriteSnapshot(&rdb, entries, repl); err != nil {
+		log.Println("[PsyncHandler] Error encoding snapshot: ", err)
+		deregisterReplica(r, "error encoding snapshot")
+		return
+	}
+	header := []byte(fmt.Sprintf("$%d\r\n", rdb.Len()))
+	tw := newTransferWriter(p.conn)
+	for _, b := range [][]byte{fullresync, header, rdb.Bytes()} {
+		if _, err := tw.Write(b); err != nil {
+			log.Println("[PsyncHandler] Error writing to replica: ", err)
+			deregisterReplica(r, err.Error())
+			return
+		}
+	}
+	log.Printf("[PsyncHandler] Sent %d byte snapshot to %s\n", rdb.Len(), r.addr)
+	tw.done()
+	r.start()
+}
+
+// Size of the writes a snapshot is sent in; see transferWriter.
+const transferChunkSize = 64 * 1024
+
+// transferWriter writes a snapshot to a replica, giving up once it's taken
+// nothing for repl-timeout seconds. The replica isn't online yet, so it isn't
+// timed out for not sending ACKs.
+type transferWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func newTransferWriter(conn net.Conn) *transferWriter {
+	timeout := time.Duration(configInt("repl-timeout", defaultReplTimeout, 1)) * time.Second
+	return &transferWriter{conn: conn, timeout: timeout}
+}
+
+// Write writes `p` in chunks, each with its own deadline, so that a large
+// snapshot isn't cut off while it's still being taken.
+func (w *transferWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), transferChunkSize)]
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+		n, err := w.conn.Write(chunk)
+		written += n
 		if err != nil {
-			log.Printf("[PsyncHandler] Error reading file %s: %s\n", dbfilepath, err)
-			return p.fmtErr("Unexpected server error")
+			return written, err
 		}
+		p = p[n:]
 	}
-	filedata := fmt.Sprintf("$%d\r\n%s", len(filebytes), filebytes)
-	resp = append(resp, CommandResponse{[]byte(filedata)}...)
-	return resp
+	return written, nil
+}
+
+// done clears the deadline once the snapshot's been sent.
+func (w *transferWriter) done() {
+	w.conn.SetWriteDeadline(time.Time{})
 }
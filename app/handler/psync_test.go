@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+func TestCanContinue(t *testing.T) {
+	const (
+		replid  = "1111111111111111111111111111111111111111"
+		replid2 = "2222222222222222222222222222222222222222"
+		other   = "3333333333333333333333333333333333333333"
+	)
+	tests := []struct {
+		name         string
+		replid2      string
+		secondOffset string
+		psyncReplid  string
+		psyncOffset  int64
+		want         bool
+	}{
+		{"current ID", replid2, "100", replid, 500, true},
+		{"previous ID, before the switch", replid2, "100", replid2, 50, true},
+		{"previous ID, at the switch", replid2, "100", replid2, 100, true},
+		{"previous ID, past the switch", replid2, "100", replid2, 101, false},
+		{"previous ID, never switched", "0000000000000000000000000000000000000000", "-1", replid2, 1, false},
+		{"unknown ID", replid2, "100", other, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Set("master_replid", replid)
+			config.Set("master_replid2", tt.replid2)
+			config.Set("second_repl_offset", tt.secondOffset)
+			if got := canContinue(tt.psyncReplid, tt.psyncOffset); got != tt.want {
+				t.Errorf("canContinue(%q, %d) = %v, want %v", tt.psyncReplid, tt.psyncOffset, got, tt.want)
+			}
+		})
+	}
+}
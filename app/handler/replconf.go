@@ -4,37 +4,61 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 )
 
 type ReplconfHandler = Handler
 
-// REPLCONF
-// Internal command used to configure replication. Just reply OK for now
+// REPLCONF option value [option value ...]
+// Internal command used to configure replication.
+// Options:
+// * listening-port -- Sent by replicas during the handshake; just reply OK.
+// * capa capability -- Sent by replicas with what they support, e.g. "eof".
+// * ACK offset -- Sent by replicas with the offset they've processed. No reply.
+// * GETACK * -- Sent by our master, asking for an ACK.
 func newReplconfHandler(ctx *Ctx) ReplconfHandler {
 	args := ctx.GetArgs()
 	clientAddr := ctx.GetClientAddr()
 	conn := ctx.GetConn()
-	return &replconfHandler{clientAddr, conn, baseHandler{args: args}}
+	return &replconfHandler{clientAddr, conn, ctx.master != nil, baseHandler{args: args}}
 }
 
 type replconfHandler struct {
 	clientAddr string
 	conn       net.Conn
+	fromMaster bool
 	baseHandler
 }
 
 func (r *replconfHandler) execute() CommandResponse {
+	// REPLCONF expects pairs of arguments
+	if len(r.args)%2 != 0 {
+		return r.fmtErr("syntax error")
+	}
 	for len(r.args) > 0 {
-		// We should be getting pairs of values.
-		if len(r.args) < 2 {
-			log.Printf("[ReplconfHandler] Oh no! Incorrect # of args -- %#v\n", r.args)
-		}
-		switch r.args[0] {
-		case "listening-port":
-			port := r.args[1]
-			addr := fmt.Sprintf("%s:%s", r.clientAddr, port)
-			registerReplica(addr, r.conn)
+		opt := strings.ToLower(fmt.Sprint(r.args[0]))
+		switch opt {
+		case "ack":
+			// Replicas don't expect a reply to ACK.
+			offset, err := strconv.ParseInt(fmt.Sprint(r.args[1]), 10, 64)
+			if err != nil || r.conn == nil {
+				return CommandResponse{}
+			}
+			recordAck(r.conn, offset)
+			return CommandResponse{}
+		case "capa":
+			recordCapa(r.conn, strings.ToLower(fmt.Sprint(r.args[1])))
+		case "getack":
+			if !r.fromMaster {
+				return CommandResponse{}
+			}
+			// The offset doesn't include this command yet; it's counted once
+			// it's been handled.
+			return CommandResponse{ackCommand()}
 		default:
+			// Replicas are registered by PSYNC, once they're ready for the
+			// replication stream.
 			log.Printf("[ReplconfHandler] %q => %q\n", r.args[0], r.args[1])
 		}
 		r.args = r.args[2:]
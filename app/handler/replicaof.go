@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+type ReplicaofHandler = Handler
+
+// REPLICAOF host port | NO ONE
+// Makes the server a replica of the master at host:port, or stops replicating
+// and turns it into a master. A replica that's switched masters keeps its
+// dataset until the new master sends another, and asks to continue from its
+// offset. A promoted replica takes a new replication ID, and keeps the old one
+// so that replicas of its old master can continue from where they were.
+// SLAVEOF is an alias.
+func newReplicaofHandler(ctx *Ctx) ReplicaofHandler {
+	args := ctx.GetArgs()
+	return &replicaofHandler{baseHandler{args: args}}
+}
+
+type replicaofHandler struct {
+	baseHandler
+}
+
+func (r *replicaofHandler) execute() CommandResponse {
+	// REPLICAOF expects exactly two arguments
+	if !r.argsExactly(2) {
+		return r.fmtErr("wrong number of arguments for command")
+	}
+	host, port := fmt.Sprint(r.args[0]), fmt.Sprint(r.args[1])
+	// REPLICAOF is an exclusive command; see Handle.
+	replicaof, _ := config.Get("replicaof")
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		if replicaof != "" {
+			stopReplication()
+			shiftReplid(config.GenerateID())
+			cachedMaster.replid = ""
+			// Our replicas reconnect to learn the new ID, and continue.
+			disconnectReplicas("replication ID changed")
+			config.Set("replicaof", "")
+			replid, _ := config.Get("master_replid")
+			log.Printf("[ReplicaofHandler] Now a master, with replication ID %s\n", replid)
+		}
+		return r.fmtSimpleString("OK")
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return r.fmtErr("Invalid master port")
+	}
+	if replicaof == host+" "+port {
+		return r.fmtSimpleString("OK Already connected to specified master")
+	}
+	if replicaof == "" {
+		// Our dataset follows our own stream; ask the new master to
+		// continue from it, in case it's one of our replicas being promoted.
+		replid, _ := config.Get("master_replid")
+		cachedMaster.replid = replid
+		// Our replicas have to resync, with whatever we're sent.
+		disconnectReplicas("master is now a replica")
+	}
+	config.Set("replicaof", host+" "+port)
+	log.Printf("[ReplicaofHandler] Now a replica of %s:%s\n", host, port)
+	StartReplication(net.JoinHostPort(host, port))
+	return r.fmtSimpleString("OK")
+}
+
+// shiftReplid moves to the replication ID `replid`, as the stream's starting a
+// new history from our offset. Replicas that followed the old ID up to here
+// can still continue with it.
+func shiftReplid(replid string) {
+	old, _ := config.Get("master_replid")
+	config.Set("master_replid2", old)
+	config.Set("second_repl_offset", strconv.FormatInt(backlog.getOffset()+1, 10))
+	config.Set("master_replid", replid)
+}
@@ -2,16 +2,21 @@ package handler
 
 import (
 	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/cache"
 	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
 )
 
 type ReplicationClient = Client
@@ -19,31 +24,132 @@ type ReplicationClient = Client
 // ReplicationClient initializes the replica following for a master database
 // accessible at `addr`, in the format "host:port".
 func NewReplicationClient(addr string) ReplicationClient {
-	return &replicationClient{addr, nil, baseHandler{}}
+	return newReplicationClient(addr)
+}
+
+func newReplicationClient(addr string) *replicationClient {
+	return &replicationClient{addr: addr, stop: make(chan struct{})}
+}
+
+// The client following our master, if we're a replica. It's only changed by
+// exclusive commands (REPLICAOF), so it can be read by any running command.
+var currentMaster *replicationClient
+
+// StartReplication starts following the master at `addr`, in the format
+// "host:port", in place of any master we're following already.
+func StartReplication(addr string) {
+	stopReplication()
+	currentMaster = newReplicationClient(addr)
+	go currentMaster.Run()
+}
+
+// stopReplication stops following our master, if we have one.
+func stopReplication() {
+	if currentMaster != nil {
+		currentMaster.Stop()
+		currentMaster = nil
+	}
 }
 
 type replicationClient struct {
 	addr string
-	conn net.Conn
+	// Closed once we've stopped following the master.
+	stop     chan struct{}
+	stopOnce sync.Once
+	conn     net.Conn
+	// All reads from `conn` go through `reader`, since the RDB payload and
+	// the replication stream may arrive along with the PSYNC response.
+	reader *bufio.Reader
+	// Held for writes to `conn` once the replication stream has started,
+	// since ACKs are sent from more than one goroutine.
+	writeMu sync.Mutex
 	baseHandler
 }
 
+// How often we ACK our replication offset to the master.
+const replAckInterval = time.Second
+
+// Bounds on the delay between attempts to connect to the master; it doubles
+// with each failed attempt.
+const (
+	replRetryMinDelay = time.Second
+	replRetryMaxDelay = 30 * time.Second
+)
+
+// Run follows the master until the server shuts down: it syncs with the
+// master and handles the replication stream, reconnecting whenever the link
+// is lost. Reconnections ask to continue from our offset, so only a replica
+// that's fallen too far behind is sent the whole dataset again.
+func (r *replicationClient) Run() {
+	delay := replRetryMinDelay
+	for {
+		if err := r.Init(); err != nil {
+			if r.stopped() {
+				return
+			}
+			log.Printf("[ReplicationClient] Unable to sync with master %s: %s\n", r.addr, err)
+		} else {
+			delay = replRetryMinDelay
+			r.Handle()
+			if r.stopped() {
+				return
+			}
+		}
+		log.Printf("[ReplicationClient] Reconnecting to master in %s\n", delay)
+		select {
+		case <-ShuttingDown():
+			return
+		case <-r.stop:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, replRetryMaxDelay)
+	}
+}
+
+// Stop stops following the master, closing the connection to it. Commands
+// from the master that are already being handled are discarded; see Handle.
+func (r *replicationClient) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+func (r *replicationClient) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
 // Ping handshake.
-func (r *replicationClient) Init() error {
+func (r *replicationClient) Init() (err error) {
 	conn, err := net.Dial("tcp", r.addr)
 	if err != nil {
 		return err
 	}
-	r.conn = conn
-
-	respParser := parser.NewRESPParser(bufio.NewScanner(conn))
-	readSingleByte := func() (byte, error) {
-		buf := make([]byte, 1)
-		if _, err := conn.Read(buf); err != nil {
-			return byte(0), err
+	defer func() {
+		if err != nil {
+			conn.Close()
 		}
-		return buf[0], nil
+	}()
+	// Once stopped, Stop has closed any connection we've set, so check we
+	// haven't been before setting this one.
+	r.writeMu.Lock()
+	if r.stopped() {
+		r.writeMu.Unlock()
+		return errReplicationStopped
 	}
+	r.conn = conn
+	r.writeMu.Unlock()
+	r.reader = bufio.NewReader(ioTracker{conn})
+
+	respParser := parser.NewRESPParser(r.reader)
 
 	sendCmd := func(cmd []string) (string, error) {
 		// Build request.
@@ -81,72 +187,321 @@ func (r *replicationClient) Init() error {
 	if _, err = sendCmd([]string{"REPLCONF", "listening-port", listeningPort}); err != nil {
 		return err
 	}
-	// Send REPLCONF capa psync2
-	if _, err = sendCmd([]string{"REPLCONF", "capa", "psync2"}); err != nil {
+	// Send REPLCONF capa eof capa psync2
+	if _, err = sendCmd([]string{"REPLCONF", "capa", "eof", "capa", "psync2"}); err != nil {
 		return err
 	}
-	// Send PSYNC ? -1
-	if _, err := sendCmd([]string{"PSYNC", "?", "-1"}); err != nil {
-		return err
+	// Send PSYNC, asking to continue from the restored replication state if
+	// there is one; otherwise PSYNC ? -1.
+	psync := []string{"PSYNC", "?", "-1"}
+	if !r.apply(func() {
+		if cachedMaster.replid != "" {
+			psync = []string{"PSYNC", cachedMaster.replid, strconv.FormatInt(backlog.getOffset()+1, 10)}
+		}
+	}) {
+		return errReplicationStopped
 	}
-	// Assume FULLRESYNC, read rdb data
-	b, err := readSingleByte()
+	psyncResp, err := sendCmd(psync)
 	if err != nil {
-		// Sometimes we don't get data, check for EOF
-		if err == io.EOF {
-			log.Println("[ReplicationClient] EOF read; no RDB data sent")
-			return nil
+		return err
+	}
+	// +CONTINUE [<REPL_ID>]: our dataset is still current, and the
+	// replication stream picks up where it left off.
+	if fields := strings.Fields(psyncResp); len(fields) > 0 && fields[0] == "CONTINUE" {
+		if !r.apply(func() {
+			replid, _ := config.Get("master_replid")
+			if len(fields) > 1 && fields[1] != replid {
+				// The master's been promoted since; our replicas
+				// reconnect to learn its ID, and continue.
+				shiftReplid(fields[1])
+				cachedMaster.replid = fields[1]
+				disconnectReplicas("replication ID changed")
+			}
+		}) {
+			return errReplicationStopped
 		}
+		log.Printf("[ReplicationClient] Partial resync from offset %s\n", psync[2])
+		setLinkUp(true)
+		return nil
+	}
+	// +FULLRESYNC <REPL_ID> <OFFSET>: adopt the master's replication state,
+	// so it's saved along with the dataset that follows. Until it's loaded,
+	// our dataset isn't one we can continue from.
+	fields := strings.Fields(psyncResp)
+	fullresync := len(fields) == 3 && fields[0] == "FULLRESYNC"
+	var offset int64
+	if fullresync {
+		if offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return fmt.Errorf("invalid offset in PSYNC response %q", psyncResp)
+		}
+	}
+	if !r.apply(func() {
+		cachedMaster.replid = ""
+		if fullresync {
+			config.Set("master_replid", fields[1])
+			backlog.reset(offset)
+		}
+		// Our replicas' datasets are no longer ours; they have to resync.
+		disconnectReplicas("full resync from master")
+	}) {
+		return errReplicationStopped
+	}
+	// Assume FULLRESYNC, read rdb data
+	setSyncing(true)
+	defer setSyncing(false)
+	if err := r.loadRDB(); err != nil {
 		return err
 	}
-	// RDB data is encoded as '$<size>\r\n<content>'.
-	if b != byte('$') {
-		return fmt.Errorf("unexpected byte flag response from PSYNC: %b", b)
+	if !r.apply(func() {
+		if fullresync {
+			cachedMaster.replid = fields[1]
+		}
+	}) {
+		return errReplicationStopped
 	}
-	// Read until \r
-	sizeBytes := []byte{}
-	for {
-		b, err = readSingleByte()
+	setLinkUp(true)
+	return nil
+}
+
+var errReplicationStopped = errors.New("replication stopped")
+
+// apply runs `f`, which updates the replication state, with other commands
+// held off; unless we've stopped following the master (e.g. REPLICAOF has
+// switched masters), in which case it returns false.
+func (r *replicationClient) apply(f func()) bool {
+	execMu.Lock()
+	defer execMu.Unlock()
+	if r != currentMaster {
+		return false
+	}
+	f()
+	return true
+}
+
+// Length of the mark delimiting diskless transfers.
+const rdbEOFMarkLen = 40
+
+// loadRDB loads the dataset sent by the master after +FULLRESYNC, parsing it
+// as it arrives. It's sent either as '$<size>\r\n<content>', or by diskless
+// masters as '$EOF:<mark>\r\n<content><mark>', where mark is 40 random bytes.
+func (r *replicationClient) loadRDB() error {
+	// Masters send empty lines to keep the link alive while they prepare the
+	// RDB data.
+	var line string
+	for line == "" {
+		l, err := r.reader.ReadString('\n')
 		if err != nil {
+			// Sometimes we don't get data, check for EOF
+			if err == io.EOF && l == "" {
+				log.Println("[ReplicationClient] EOF read; no RDB data sent")
+				return nil
+			}
 			return err
 		}
-		if b == byte('\r') {
-			// Discard next byte, '\n'.
-			readSingleByte()
-			break
+		line = strings.TrimRight(l, "\r\n")
+	}
+	if !strings.HasPrefix(line, "$") {
+		return fmt.Errorf("unexpected response preceding RDB data: %q", line)
+	}
+	var (
+		rdb   io.Reader = r.reader
+		sized *io.LimitedReader
+		mark  string
+	)
+	if m, ok := strings.CutPrefix(line, "$EOF:"); ok {
+		if len(m) != rdbEOFMarkLen {
+			return fmt.Errorf("invalid EOF mark preceding RDB data: %q", line)
+		}
+		mark = m
+	} else {
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("invalid RDB data size: %q", line)
 		}
-		sizeBytes = append(sizeBytes, b)
+		sized = &io.LimitedReader{R: r.reader, N: size}
+		rdb = sized
 	}
-	// Parse size; read RDB data.
-	size, err := strconv.Atoi(string(sizeBytes))
-	if err != nil {
-		return err
+	// Clear local data and load the master's
+	if _, err := cache.GetDefaultCache().LoadRDB(rdb); err != nil {
+		return fmt.Errorf("invalid RDB data from master: %w", err)
 	}
-	rdbBytes := make([]byte, size)
-	if _, err := conn.Read(rdbBytes); err != nil {
-		return err
+	if sized != nil && sized.N > 0 {
+		log.Printf("[ReplicationClient] Skipping %d bytes following the RDB data\n", sized.N)
+		if _, err := io.Copy(io.Discard, sized); err != nil {
+			return err
+		}
+	}
+	if mark != "" {
+		end := make([]byte, rdbEOFMarkLen)
+		if _, err := io.ReadFull(r.reader, end); err != nil {
+			return err
+		}
+		if string(end) != mark {
+			return fmt.Errorf("RDB data from master isn't followed by the EOF mark")
+		}
+	}
+	// The AOF no longer matches the dataset; start it over from the new one.
+	if persistence.GetAOFStatus().Enabled {
+		if err := persistence.BGRewriteAOF(); err != nil {
+			log.Println("[ReplicationClient] Error rewriting AOF: ", err)
+		}
 	}
-	rdbData := parser.NewRDBParser(bytes.NewBuffer(rdbBytes)).Parse()
-	// Clear local data and load rdbData
-	cache.GetDefaultCache().LoadRDB(rdbData)
 	return nil
 }
 
+// The replication ID of the stream our dataset follows, restored from the RDB
+// file at startup or learned from the master, so that after losing the link
+// (or restarting) we can ask the master to continue where we left off. The
+// offset to continue from is that of our backlog. Guarded by execMu.
+var cachedMaster struct {
+	replid string
+}
+
+// State of the link with the master, reported by INFO.
+var replLink struct {
+	mu sync.Mutex
+	up bool
+	// Whether we're loading a full resync from the master.
+	syncing bool
+	// When we last read from the master.
+	lastIO time.Time
+	// When the link was lost; zero if it's never been up.
+	downSince time.Time
+}
+
+func setLinkUp(up bool) {
+	replLink.mu.Lock()
+	defer replLink.mu.Unlock()
+	if replLink.up && !up {
+		replLink.downSince = time.Now()
+	}
+	replLink.up = up
+}
+
+func setSyncing(syncing bool) {
+	replLink.mu.Lock()
+	defer replLink.mu.Unlock()
+	replLink.syncing = syncing
+}
+
+// masterLinkInfo returns whether the link with the master is up, whether a
+// full resync is in progress, and the times of the last read from the master
+// and of the link going down.
+func masterLinkInfo() (up, syncing bool, lastIO, downSince time.Time) {
+	replLink.mu.Lock()
+	defer replLink.mu.Unlock()
+	return replLink.up, replLink.syncing, replLink.lastIO, replLink.downSince
+}
+
+// ioTracker records when data was last read from the master, and gives up on
+// reads once the master's been silent for repl-timeout seconds. Masters PING
+// their replicas while there's nothing else to send.
+type ioTracker struct {
+	net.Conn
+}
+
+func (t ioTracker) Read(p []byte) (int, error) {
+	timeout := time.Duration(configInt("repl-timeout", defaultReplTimeout, 1)) * time.Second
+	t.Conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := t.Conn.Read(p)
+	if os.IsTimeout(err) {
+		log.Printf("[ReplicationClient] No data from master in %s\n", timeout)
+	}
+	if n > 0 {
+		replLink.mu.Lock()
+		replLink.lastIO = time.Now()
+		replLink.mu.Unlock()
+	}
+	return n, err
+}
+
+// RestoreReplicationInfo restores the replication ID and offset saved in an
+// RDB file's "repl-id" and "repl-offset" aux fields, if it has them.
+func RestoreReplicationInfo(aux map[string]string) {
+	replid, ok := aux["repl-id"]
+	if !ok || len(replid) != 40 {
+		return
+	}
+	offset, err := strconv.ParseInt(aux["repl-offset"], 10, 64)
+	if err != nil || offset < 0 {
+		return
+	}
+	log.Printf("[ReplicationClient] Restored replication ID %s and offset %d\n", replid, offset)
+	config.Set("master_replid", replid)
+	backlog.reset(offset)
+	cachedMaster.replid = replid
+}
+
+// Handle applies the replication stream until the link with the master is
+// lost.
 func (r *replicationClient) Handle() {
 	defer r.conn.Close()
-	scanner := bufio.NewScanner(r.conn)
+	defer setLinkUp(false)
+	done := make(chan struct{})
+	defer close(done)
+	go r.sendAcks(done)
 
 	for {
 		cmdCtx := &Ctx{}
-		parsed := parser.NewRESPParser(scanner).Parse()
+		parsed, raw := parser.ParseRESPRaw(r.reader)
 		// Assert `parsed` is of form CommandArgs
 		command, ok := parsed.(CommandArgs)
 		if !ok || len(command) == 0 {
+			if !r.stopped() {
+				log.Printf("[ReplicationClient] Lost connection to master %s at offset %d\n", r.addr, backlog.getOffset())
+			}
 			break
 		}
-		cmdCtx.SetCmd(command[0].(string))
+		cmd, ok := command[0].(string)
+		if !ok {
+			// Run as an unknown command, so it still counts towards our
+			// offset, like the rest of the stream.
+			log.Printf("[ReplicationClient] Invalid command from master %s: %#v\n", r.addr, command)
+		}
+		cmdCtx.SetCmd(cmd)
 		cmdCtx.SetArgs(command[1:])
-		// We don't write responses in replication.
-		_ = Handle(cmdCtx)
+		cmdCtx.master = r
+		cmdCtx.raw = raw
+		// We don't write responses in replication, other than ACKs. The
+		// command is added to our backlog once it's applied; see Handle.
+		resp := Handle(cmdCtx)
+		if strings.EqualFold(cmdCtx.GetCmd(), "REPLCONF") && len(resp) > 0 {
+			if err := r.write(resp); err != nil {
+				log.Println("[ReplicationClient] Error sending ACK: ", err)
+			}
+		}
 	}
 }
+
+// sendAcks sends REPLCONF ACK <offset> to the master every replAckInterval,
+// until `done` is closed.
+func (r *replicationClient) sendAcks(done <-chan struct{}) {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := r.write(CommandResponse{ackCommand()}); err != nil {
+			log.Println("[ReplicationClient] Error sending ACK: ", err)
+		}
+	}
+}
+
+// ackCommand returns REPLCONF ACK <offset>, with our replication offset.
+func ackCommand() []byte {
+	return encodeCommand([]string{"REPLCONF", "ACK", strconv.FormatInt(backlog.getOffset(), 10)})
+}
+
+func (r *replicationClient) write(resp CommandResponse) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	for _, p := range resp {
+		if _, err := r.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,33 +3,412 @@ package handler
 import (
 	"log"
 	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+// replica is a connected replica. The replication stream is queued for each
+// replica, and written out in order by its own goroutine, so that a slow
+// replica doesn't hold up writes or the other replicas.
+type replica struct {
+	addr string
+	conn net.Conn
+
+	mu sync.Mutex
+	// Output that hasn't been written yet.
+	out []byte
+	// Signalled when there's output, or the replica's been dropped.
+	wake    chan struct{}
+	dropped bool
+	// The replication offset the replica has acknowledged, or -1 until its
+	// first ACK, and when it last did (or when it came online). Guarded by
+	// replicasMu.
+	ack     int64
+	lastAck time.Time
+	// Set once the replica's been sent its snapshot, and is being sent the
+	// replication stream. Guarded by replicasMu.
+	online bool
+}
+
+// Map of address => replica
+var (
+	replicasMu sync.Mutex
+	replicas   = make(map[string]*replica)
+	// Closed (and replaced) whenever a replica's acknowledged offset changes,
+	// or a replica's dropped. replicasMu must be held.
+	acksUpdated = make(chan struct{})
 )
 
-// Map of address => conn
-var replicas = make(map[string]net.Conn)
+// Capabilities announced with REPLCONF capa, by connections that haven't sent
+// PSYNC yet.
+var replicaCapas = make(map[net.Conn]map[string]bool)
+
+func recordCapa(conn net.Conn, capa string) {
+	if conn == nil {
+		return
+	}
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	if replicaCapas[conn] == nil {
+		replicaCapas[conn] = make(map[string]bool)
+	}
+	replicaCapas[conn][capa] = true
+}
+
+// ConnClosed forgets anything kept about `conn`, once it's been closed.
+func ConnClosed(conn net.Conn) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	delete(replicaCapas, conn)
+}
+
+// takeCapas returns the capabilities announced on `conn`, and forgets them.
+func takeCapas(conn net.Conn) map[string]bool {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	capas := replicaCapas[conn]
+	delete(replicaCapas, conn)
+	return capas
+}
 
 // For access to formatting helpers.
 var b = &baseHandler{}
 
-func registerReplica(addr string, conn net.Conn) {
-	replicas[addr] = conn
+// Default limit on the output queued for a replica, in bytes.
+const defaultReplicaOutputLimit = 256 << 20
+
+// registerReplica starts queueing the replication stream for `conn`, which
+// has been sent everything up to `offset`. Call start on the replica once
+// it's ready for the stream. It isn't counted as having any of the stream
+// until it says so with an ACK.
+func registerReplica(addr string, conn net.Conn, offset int64) *replica {
+	r := &replica{addr: addr, conn: conn, wake: make(chan struct{}, 1), ack: -1}
+	replicasMu.Lock()
+	old := replicas[addr]
+	replicas[addr] = r
+	replicasMu.Unlock()
+	if old != nil {
+		old.drop()
+	}
+	log.Printf("[Replicator] Replica %s registered at offset %d\n", addr, offset)
+	return r
+}
+
+// disconnectReplicas drops every replica.
+func disconnectReplicas(reason string) {
+	replicasMu.Lock()
+	dropped := make([]*replica, 0, len(replicas))
+	for _, r := range replicas {
+		dropped = append(dropped, r)
+	}
+	replicasMu.Unlock()
+	for _, r := range dropped {
+		deregisterReplica(r, reason)
+	}
+}
+
+// start starts writing out the replica's queued output.
+func (r *replica) start() {
+	replicasMu.Lock()
+	r.online, r.lastAck = true, time.Now()
+	replicasMu.Unlock()
+	go r.writeLoop()
+}
+
+// deregisterReplica stops replicating to `r`, and closes its connection.
+func deregisterReplica(r *replica, reason string) {
+	replicasMu.Lock()
+	if replicas[r.addr] == r {
+		delete(replicas, r.addr)
+		close(acksUpdated)
+		acksUpdated = make(chan struct{})
+	}
+	replicasMu.Unlock()
+	if r.drop() {
+		log.Printf("[Replicator] Dropped replica %s: %s\n", r.addr, reason)
+	}
+}
+
+// drop discards the replica's queued output and closes its connection.
+// Returns false if it had already been dropped.
+func (r *replica) drop() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dropped {
+		return false
+	}
+	r.dropped = true
+	r.out = nil
+	r.conn.Close()
+	r.signal()
+	return true
+}
+
+// signal wakes the write loop; r.mu must be held.
+func (r *replica) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
 }
 
-// Not currently used.
-// func dregisterReplica(addr string) {
-// 	delete(replicas, addr)
-// }
+// bufferedConn is a connection that buffers writes itself, and so accepts
+// them straight away however far behind the other side is, like the event
+// loop's.
+type bufferedConn interface {
+	// Buffered returns the number of bytes written but not yet sent.
+	Buffered() int
+}
 
-func notifyReplicas(cmd []string) {
+// enqueue queues `command` for the replica. Returns false if that takes its
+// output, including any the connection has buffered, over `limit`.
+func (r *replica) enqueue(command []byte, limit int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dropped {
+		return true
+	}
+	queued := len(r.out)
+	if bc, ok := r.conn.(bufferedConn); ok {
+		queued += bc.Buffered()
+	}
+	if queued+len(command) > limit {
+		return false
+	}
+	r.out = append(r.out, command...)
+	r.signal()
+	return true
+}
+
+// writeLoop writes out the replica's output as it's queued, until it's
+// dropped.
+func (r *replica) writeLoop() {
+	for range r.wake {
+		r.mu.Lock()
+		if r.dropped {
+			r.mu.Unlock()
+			return
+		}
+		out := r.out
+		r.out = nil
+		r.mu.Unlock()
+		if len(out) == 0 {
+			continue
+		}
+		if _, err := r.conn.Write(out); err != nil {
+			deregisterReplica(r, err.Error())
+			return
+		}
+	}
+}
+
+// recordAck records that the replica on `conn` has processed the stream up to
+// `offset`.
+func recordAck(conn net.Conn, offset int64) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	r, ok := replicas[conn.RemoteAddr().String()]
+	if !ok || r.conn != conn {
+		log.Printf("[Replicator] Ignoring ACK from %s, which isn't a replica\n", conn.RemoteAddr())
+		return
+	}
+	r.ack, r.lastAck = offset, time.Now()
+	close(acksUpdated)
+	acksUpdated = make(chan struct{})
+}
+
+// countAcks returns the number of replicas that have acknowledged `offset`,
+// along with a channel that's closed when that might have changed.
+func countAcks(offset int64) (int, <-chan struct{}) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	n := 0
+	for _, r := range replicas {
+		if r.ack >= offset {
+			n++
+		}
+	}
+	return n, acksUpdated
+}
+
+// countGoodReplicas returns the number of online replicas, and how many of
+// those have sent an ACK in the last min-replicas-max-lag seconds.
+func countGoodReplicas() (online, good int) {
+	maxLag := configInt("min-replicas-max-lag", defaultMinReplicasMaxLag, 0)
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	for _, r := range replicas {
+		if !r.online {
+			continue
+		}
+		online++
+		if int(time.Since(r.lastAck)/time.Second) <= maxLag {
+			good++
+		}
+	}
+	return online, good
+}
+
+// enoughGoodReplicas returns false if we're a master with fewer good replicas
+// than min-replicas-to-write; see countGoodReplicas.
+func enoughGoodReplicas() bool {
+	if replicaof, _ := config.Get("replicaof"); replicaof != "" {
+		return true
+	}
+	minReplicas := configInt("min-replicas-to-write", 0, 0)
+	if minReplicas == 0 {
+		return true
+	}
+	_, good := countGoodReplicas()
+	return good >= minReplicas
+}
+
+// Defaults for the replication timing config.
+const (
+	defaultReplPingPeriod    = 10
+	defaultReplTimeout       = 60
+	defaultMinReplicasMaxLag = 10
+)
+
+// configInt returns the config value `key` as an integer, or `def` if it isn't
+// one, or is less than `min`.
+func configInt(key string, def, min int) int {
+	s, _ := config.Get(key)
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min {
+		return def
+	}
+	return n
+}
+
+// RunReplicationCron sends replicas a PING every repl-ping-replica-period
+// seconds, so they can tell the link's alive while there's nothing to
+// replicate, and drops replicas that haven't sent an ACK in repl-timeout
+// seconds. It runs until the server shuts down.
+func RunReplicationCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastPing time.Time
+	for {
+		select {
+		case <-ShuttingDown():
+			return
+		case <-ticker.C:
+		}
+		period := time.Duration(configInt("repl-ping-replica-period", defaultReplPingPeriod, 1)) * time.Second
+		if time.Since(lastPing) >= period {
+			pingReplicas()
+			lastPing = time.Now()
+		}
+		dropTimedOutReplicas()
+	}
+}
+
+// pingReplicas sends PING down the replication stream, if we're a master with
+// replicas. Replicas pass on their master's PINGs instead.
+func pingReplicas() {
+	// Held like a write, so that the stream is in order.
+	execMu.Lock()
+	defer execMu.Unlock()
+	if replicaof, _ := config.Get("replicaof"); replicaof != "" {
+		return
+	}
+	replicasMu.Lock()
+	n := len(replicas)
+	replicasMu.Unlock()
+	if n == 0 {
+		return
+	}
+	ping := encodeCommand([]string{"PING"})
+	backlog.write(ping)
+	notifyReplicas(ping)
+}
+
+// dropTimedOutReplicas drops online replicas that haven't sent an ACK in
+// repl-timeout seconds.
+func dropTimedOutReplicas() {
+	timeout := time.Duration(configInt("repl-timeout", defaultReplTimeout, 1)) * time.Second
+	replicasMu.Lock()
+	var timedOut []*replica
+	for _, r := range replicas {
+		if r.online && time.Since(r.lastAck) > timeout {
+			timedOut = append(timedOut, r)
+		}
+	}
+	replicasMu.Unlock()
+	for _, r := range timedOut {
+		deregisterReplica(r, "timeout")
+	}
+}
+
+// encodeCommand formats `cmd` as a RESP array, as it's sent to replicas.
+func encodeCommand(cmd []string) []byte {
 	command := []byte{}
 	command = append(command, b.fmtArrayLen(len(cmd))[0]...)
 	for _, el := range cmd {
 		command = append(command, b.fmtBulkString(el)[0]...)
 	}
+	return command
+}
+
+// notifyReplicas queues `command` for every replica. Replicas that have
+// fallen too far behind are dropped; they'll have to resync.
+func notifyReplicas(command []byte) {
+	limit := replicaOutputLimit()
+	replicasMu.Lock()
+	var overLimit []*replica
+	for _, r := range replicas {
+		if !r.enqueue(command, limit) {
+			overLimit = append(overLimit, r)
+		}
+	}
+	replicasMu.Unlock()
+	for _, r := range overLimit {
+		deregisterReplica(r, "output buffer limit reached")
+	}
+}
 
-	for addr, conn := range replicas {
-		if _, err := conn.Write(command); err != nil {
-			log.Printf("[notifyReplicas] Error response from %q: %s\n", addr, err)
+// replicaOutputLimit returns the limit on the output queued for a replica.
+func replicaOutputLimit() int {
+	return configInt("replica-output-buffer-limit", defaultReplicaOutputLimit, 1)
+}
+
+// waitForReplicas waits up to `timeout` for every replica to acknowledge our
+// current replication offset, returning false if they didn't. execMu mustn't
+// be held, since ACKs are commands.
+func waitForReplicas(timeout time.Duration) bool {
+	execMu.Lock()
+	offset := backlog.getOffset()
+	n, _ := countAcks(offset)
+	// Ask for ACKs, as WAIT does. Replicas pass on their master's stream, so
+	// they wait for their replicas' periodic ACKs instead.
+	if replicaof, _ := config.Get("replicaof"); replicaof == "" && n < numReplicas() {
+		getack := encodeCommand([]string{"REPLCONF", "GETACK", "*"})
+		backlog.write(getack)
+		notifyReplicas(getack)
+	}
+	execMu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		n, updated := countAcks(offset)
+		if n >= numReplicas() {
+			return true
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return false
 		}
 	}
 }
+
+// numReplicas returns the number of replicas, online or not.
+func numReplicas() int {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	return len(replicas)
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+type RestoreHandler = Handler
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+//
+// Create a key associated with a value that is obtained by deserializing the
+// provided serialized value (obtained via DUMP).
+// Options:
+// * ttl -- Expire time in milliseconds, or 0 for no expiry.
+// * REPLACE -- Overwrite the key if it already exists.
+// * ABSTTL -- ttl is an absolute Unix time in milliseconds.
+// * IDLETIME seconds -- Set the key's LRU idle time.
+// * FREQ frequency -- Set the key's LFU counter.
+//
+// A relative ttl is logged and replicated as ABSTTL, so that the key expires
+// at the same time however much later the command's applied.
+func newRestoreHandler(ctx *Ctx) RestoreHandler {
+	args := ctx.GetArgs()
+	return &restoreHandler{cache: cache.GetDefaultCache(), baseHandler: baseHandler{args: args}}
+}
+
+type restoreHandler struct {
+	cache *cache.Cache
+	// The command as it's propagated, once the key's been restored.
+	propagated []string
+	baseHandler
+}
+
+func (r *restoreHandler) execute() CommandResponse {
+	// RESTORE expects at least three arguments
+	if !r.argsAtLeast(3) {
+		return r.fmtErr("wrong number of arguments for command")
+	}
+	args := make([]string, 0, len(r.args))
+	for _, arg := range r.args {
+		s, ok := arg.(string)
+		if !ok {
+			return r.fmtErr("syntax error")
+		}
+		args = append(args, s)
+	}
+	key, payload := args[0], args[2]
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return r.fmtErr("value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return r.fmtErr("Invalid TTL value, must be >= 0")
+	}
+
+	// Parse options
+	var (
+		replace, absTTL bool
+		e               = parser.RDBEntry{Key: []byte(key)}
+	)
+	options := args[3:]
+	for len(options) > 0 {
+		opt, rest := strings.ToUpper(options[0]), options[1:]
+		switch {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case opt == "IDLETIME" && len(rest) > 0 && !e.HasFreq:
+			idle, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil {
+				return r.fmtErr("value is not an integer or out of range")
+			}
+			if idle < 0 {
+				return r.fmtErr("Invalid IDLETIME value, must be >= 0")
+			}
+			e.Idle, e.HasIdle = time.Duration(idle)*time.Second, true
+			rest = rest[1:]
+		case opt == "FREQ" && len(rest) > 0 && !e.HasIdle:
+			freq, err := strconv.ParseInt(rest[0], 10, 64)
+			if err != nil {
+				return r.fmtErr("value is not an integer or out of range")
+			}
+			if freq < 0 || freq > 255 {
+				return r.fmtErr("Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			e.Freq, e.HasFreq = uint8(freq), true
+			rest = rest[1:]
+		default:
+			return r.fmtErr("syntax error")
+		}
+		// Set options for next loop
+		options = rest
+	}
+
+	val, err := parser.DecodeDump([]byte(payload))
+	if err != nil {
+		if err != parser.ErrDumpPayload {
+			log.Println("[RestoreHandler] Error decoding payload: ", err)
+			return r.fmtErr("Bad data format")
+		}
+		return r.fmtErr(err.Error())
+	}
+	e.Value = val
+	switch {
+	case ttl == 0:
+	case absTTL:
+		e.Expiry = time.UnixMilli(ttl)
+	default:
+		e.Expiry = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	if err := r.cache.Restore(e, replace); err != nil {
+		if err == cache.ErrBusyKey {
+			return r.fmtErrCode("BUSYKEY", "Target key name already exists.")
+		}
+		log.Println("[RestoreHandler] Error restoring value: ", err)
+		return r.fmtErr("Bad data format")
+	}
+	r.propagated = []string{"RESTORE", key, "0", payload}
+	if !e.Expiry.IsZero() {
+		r.propagated[2] = strconv.FormatInt(e.Expiry.UnixMilli(), 10)
+		r.propagated = append(r.propagated, "ABSTTL")
+	}
+	if replace {
+		r.propagated = append(r.propagated, "REPLACE")
+	}
+	if e.HasIdle {
+		r.propagated = append(r.propagated, "IDLETIME", strconv.FormatInt(int64(e.Idle/time.Second), 10))
+	}
+	if e.HasFreq {
+		r.propagated = append(r.propagated, "FREQ", strconv.Itoa(int(e.Freq)))
+	}
+	return r.fmtSimpleString("OK")
+}
+
+func (r *restoreHandler) propagate() []string {
+	return r.propagated
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+)
+
+type SaveHandler = Handler
+
+// SAVE
+// The SAVE commands performs a synchronous save of the dataset producing a
+// point in time snapshot of all the data inside the Redis instance, in the
+// form of an RDB file.
+func newSaveHandler(ctx *Ctx) SaveHandler {
+	args := ctx.GetArgs()
+	return &saveHandler{baseHandler{args: args}}
+}
+
+type saveHandler struct {
+	baseHandler
+}
+
+func (s *saveHandler) execute() CommandResponse {
+	// SAVE expects no arguments
+	if !s.argsExactly(0) {
+		return s.fmtErr("wrong number of arguments for command")
+	}
+	if err := persistence.Save(); err != nil {
+		if err == persistence.ErrSaveInProgress {
+			return s.fmtErr(err.Error())
+		}
+		log.Println("[SaveHandler] Error saving: ", err)
+		return s.fmtErr("Unexpected server error")
+	}
+	return s.fmtSimpleString("OK")
+}
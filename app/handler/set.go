@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"fmt"
 	"log"
 	"strconv"
 	"strings"
@@ -11,7 +12,8 @@ import (
 
 type SetHandler = Handler
 
-// SET key value [NX | XX] [EX seconds | PX milliseconds ]
+// SET key value [NX | XX] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds]
 //
 // Set key to hold the string value. If key already holds a value, it is
 // overwritten, regardless of its type.
@@ -19,15 +21,22 @@ type SetHandler = Handler
 // The SET command supports a (sub)set of options that modify its behavior:
 // * EX seconds -- Set the specified expire time, in seconds (a positive integer).
 // * PX milliseconds -- Set the specified expire time, in milliseconds (a positive integer).
+// * EXAT timestamp -- Set the specified Unix time at which the key will expire, in seconds.
+// * PXAT timestamp -- Set the specified Unix time at which the key will expire, in milliseconds.
 // * NX -- Only set the key if it does not already exist.
 // * XX -- Only set the key if it already exists.
+//
+// Expiring keys are logged and replicated with PXAT, so that they expire at
+// the same time however much later the command's applied.
 func newSetHandler(ctx *Ctx) SetHandler {
 	args := ctx.GetArgs()
-	return &setHandler{cache.GetDefaultCache(), baseHandler{args: args}}
+	return &setHandler{cache: cache.GetDefaultCache(), baseHandler: baseHandler{args: args}}
 }
 
 type setHandler struct {
 	cache *cache.Cache
+	// The command as it's propagated, once the key's been set.
+	propagated []string
 	baseHandler
 }
 
@@ -53,8 +62,12 @@ func (s *setHandler) execute() CommandResponse {
 	// Parse options
 	options := s.args[2:]
 	for len(options) > 0 {
-		opt, rest := options[0], options[1:]
-		switch strings.ToUpper(opt.(string)) {
+		opt, ok := options[0].(string)
+		if !ok {
+			return s.fmtErr("syntax error")
+		}
+		rest := options[1:]
+		switch strings.ToUpper(opt) {
 		case "NX": // Only set key if it does not exist
 			if s.cache.KeyExists(key) {
 				return s.fmtNullString()
@@ -75,7 +88,7 @@ func (s *setHandler) execute() CommandResponse {
 				return s.fmtErr("syntax error")
 			}
 			// Set expiry
-			ms, err := strconv.Atoi(rest[0].(string))
+			ms, err := strconv.Atoi(fmt.Sprint(rest[0]))
 			if err != nil {
 				log.Println("[SetHandler] Error formatting timeout: ", err)
 				return s.fmtErr("syntax error")
@@ -91,7 +104,7 @@ func (s *setHandler) execute() CommandResponse {
 				return s.fmtErr("syntax error")
 			}
 			// Set expiry
-			sec, err := strconv.Atoi(rest[0].(string))
+			sec, err := strconv.Atoi(fmt.Sprint(rest[0]))
 			if err != nil {
 				log.Println("[SetHandler] Error formatting timeout: ", err)
 				return s.fmtErr("syntax error")
@@ -99,6 +112,25 @@ func (s *setHandler) execute() CommandResponse {
 			expiry = time.Now().Add(time.Second * time.Duration(sec))
 			// Set options for next loop
 			options = rest[1:]
+		case "PXAT", "EXAT": // Set expiry at a Unix time
+			if len(rest) == 0 {
+				return s.fmtErr("syntax error")
+			}
+			if !expiry.IsZero() {
+				return s.fmtErr("syntax error")
+			}
+			at, err := strconv.ParseInt(fmt.Sprint(rest[0]), 10, 64)
+			if err != nil {
+				log.Println("[SetHandler] Error formatting timeout: ", err)
+				return s.fmtErr("syntax error")
+			}
+			if strings.ToUpper(opt) == "EXAT" {
+				expiry = time.Unix(at, 0)
+			} else {
+				expiry = time.UnixMilli(at)
+			}
+			// Set options for next loop
+			options = rest[1:]
 		default:
 			log.Println("[SetHandler] Unrecognized option for SET: ", options)
 			// Set options for next loop
@@ -106,5 +138,14 @@ func (s *setHandler) execute() CommandResponse {
 		}
 	}
 	s.cache.Set(key, value, expiry)
+	// NX and XX have been checked; replicas and the AOF set the key as is.
+	s.propagated = []string{"SET", key, value}
+	if !expiry.IsZero() {
+		s.propagated = append(s.propagated, "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10))
+	}
 	return s.fmtSimpleString("OK")
 }
+
+func (s *setHandler) propagate() []string {
+	return s.propagated
+}
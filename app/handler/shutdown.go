@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+)
+
+type ShutdownHandler = Handler
+
+// SHUTDOWN [NOSAVE | SAVE]
+// Stops the server: in-flight commands are allowed to finish, replicas are
+// given a chance to catch up, and the dataset is optionally saved before the
+// server exits. On success, no reply is sent and the connection is closed.
+// Options:
+// * NOSAVE -- Don't save, even if save points are configured.
+// * SAVE -- Save, even if no save points are configured.
+func newShutdownHandler(ctx *Ctx) ShutdownHandler {
+	args := ctx.GetArgs()
+	return &shutdownHandler{baseHandler: baseHandler{args: args}}
+}
+
+type shutdownHandler struct {
+	mode ShutdownMode
+	baseHandler
+}
+
+func (s *shutdownHandler) execute() CommandResponse {
+	mode := ShutdownDefault
+	for _, arg := range s.args {
+		opt, ok := arg.(string)
+		if !ok {
+			return s.fmtErr("syntax error")
+		}
+		switch strings.ToUpper(opt) {
+		case "NOSAVE":
+			if mode == ShutdownSave {
+				return s.fmtErr("syntax error")
+			}
+			mode = ShutdownNoSave
+		case "SAVE":
+			if mode == ShutdownNoSave {
+				return s.fmtErr("syntax error")
+			}
+			mode = ShutdownSave
+		default:
+			return s.fmtErr("syntax error")
+		}
+	}
+	// Shut down once other commands are free to run, since we wait on
+	// replicas' ACKs, which are commands too.
+	s.mode = mode
+	return nil
+}
+
+func (s *shutdownHandler) block() CommandResponse {
+	if err := Shutdown(s.mode); err != nil {
+		log.Println("[ShutdownHandler] Error shutting down: ", err)
+		return s.fmtErr("Errors trying to SHUTDOWN. Check logs.")
+	}
+	return CommandResponse{}
+}
+
+type ShutdownMode int
+
+const (
+	// Save only if save points are configured.
+	ShutdownDefault ShutdownMode = iota
+	ShutdownSave
+	ShutdownNoSave
+)
+
+var (
+	// Closed once the server is ready to exit.
+	shutdownCh = make(chan struct{})
+	// Set once shutdown has completed; guarded by execMu.
+	shuttingDown bool
+)
+
+// Shutdown prepares the server to exit, as if SHUTDOWN had been called. If an
+// error is returned, the server should keep running.
+//
+// Replicas are first given up to shutdown-timeout seconds to acknowledge the
+// replication offset as of the call, while other commands (including their
+// ACKs) keep running.
+func Shutdown(mode ShutdownMode) error {
+	shutdownTimeout, _ := config.Get("shutdown-timeout")
+	timeout, err := strconv.Atoi(shutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown-timeout %q", shutdownTimeout)
+	}
+	log.Println("[Shutdown] Waiting for replicas before shutting down.")
+	if !waitForReplicas(time.Duration(timeout) * time.Second) {
+		log.Println("[Shutdown] Timed out waiting for replicas; continuing.")
+	}
+
+	execMu.Lock()
+	defer execMu.Unlock()
+	return shutdown(mode)
+}
+
+// ShuttingDown returns a channel that's closed once the server is ready to
+// exit. From then on, no more commands are executed.
+func ShuttingDown() <-chan struct{} {
+	return shutdownCh
+}
+
+// shutdown expects execMu to be held.
+func shutdown(mode ShutdownMode) error {
+	if shuttingDown {
+		return nil
+	}
+	log.Println("[Shutdown] User requested shutdown...")
+
+	if err := persistence.FlushAOF(); err != nil {
+		log.Println("[Shutdown] Error fsyncing the AOF: ", err)
+	}
+
+	// Save points are validated at startup.
+	savePoints, _ := persistence.SavePoints()
+	if mode == ShutdownSave || (mode == ShutdownDefault && len(savePoints) > 0) {
+		persistence.WaitForSave()
+		log.Println("[Shutdown] Saving the final RDB snapshot before exiting.")
+		if err := persistence.Save(); err != nil {
+			return fmt.Errorf("error trying to save the DB: %w", err)
+		}
+	}
+
+	log.Println("[Shutdown] Ready to exit, bye bye...")
+	shuttingDown = true
+	close(shutdownCh)
+	return nil
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"log"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+)
+
+type TypeHandler = Handler
+
+// TYPE key
+// Returns the string representation of the type of the value stored at key.
+// The different types that can be returned are: string, list, set, zset, and
+// hash.
+func newTypeHandler(ctx *Ctx) TypeHandler {
+	args := ctx.GetArgs()
+	return &typeHandler{cache.GetDefaultCache(), baseHandler{args: args}}
+}
+
+type typeHandler struct {
+	cache *cache.Cache
+	baseHandler
+}
+
+func (t *typeHandler) execute() CommandResponse {
+	// TYPE expects exactly one argument
+	if !t.argsExactly(1) {
+		return t.fmtErr("wrong number of arguments for command")
+	}
+	key, ok := t.args[0].(string)
+	if !ok {
+		log.Printf("[TypeHandler] Non-string key: %#v\n", t.args[0])
+		return t.fmtErr("syntax error")
+	}
+	return t.fmtSimpleString(t.cache.Type(key))
+}
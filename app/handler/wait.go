@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+type WaitHandler = Handler
+
+// WAIT numreplicas timeout
+// Blocks until all the previous write commands are acknowledged by at least
+// numreplicas replicas, or timeout milliseconds pass (0 blocks forever).
+// Returns the number of replicas that acknowledged the writes.
+func newWaitHandler(ctx *Ctx) WaitHandler {
+	args := ctx.GetArgs()
+	return &waitHandler{baseHandler: baseHandler{args: args}}
+}
+
+type waitHandler struct {
+	numReplicas int
+	timeout     time.Duration
+	// The replication offset replicas need to have acknowledged.
+	offset int64
+	baseHandler
+}
+
+func (w *waitHandler) execute() CommandResponse {
+	// WAIT expects exactly two arguments
+	if !w.argsExactly(2) {
+		return w.fmtErr("wrong number of arguments for command")
+	}
+	if replicaof, _ := config.Get("replicaof"); replicaof != "" {
+		return w.fmtErr("WAIT cannot be used with replica instances.")
+	}
+	numReplicas, err := strconv.Atoi(fmt.Sprint(w.args[0]))
+	if err != nil {
+		return w.fmtErr("value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(fmt.Sprint(w.args[1]), 10, 64)
+	if err != nil {
+		return w.fmtErr("timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return w.fmtErr("timeout is negative")
+	}
+	w.numReplicas = numReplicas
+	w.timeout = time.Duration(timeout) * time.Millisecond
+	w.offset = backlog.getOffset()
+
+	if n, _ := countAcks(w.offset); n >= w.numReplicas {
+		return w.fmtInteger(int64(n))
+	}
+	// Ask the replicas for their offsets, rather than wait for their next
+	// periodic ACK. The request is part of the replication stream, so WAIT is
+	// run exclusively; the wait itself isn't.
+	getack := encodeCommand([]string{"REPLCONF", "GETACK", "*"})
+	backlog.write(getack)
+	notifyReplicas(getack)
+	return nil
+}
+
+// block waits for enough replicas to acknowledge the offset.
+func (w *waitHandler) block() CommandResponse {
+	var timeout <-chan time.Time
+	if w.timeout > 0 {
+		timer := time.NewTimer(w.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		n, updated := countAcks(w.offset)
+		if n >= w.numReplicas {
+			return w.fmtInteger(int64(n))
+		}
+		select {
+		case <-updated:
+		case <-timeout:
+			return w.fmtInteger(int64(n))
+		case <-ShuttingDown():
+			// Answer with what we have, so the connection can close.
+			return w.fmtInteger(int64(n))
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package parser
+
+import "hash/crc64"
+
+// RDB files are checksummed with CRC-64/Jones, reflected, with no initial or
+// final XOR. hash/crc64 inverts the CRC on the way in and out, so we undo
+// that around each update.
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// CRC64 updates `crc` with the bytes of `p`.
+func CRC64(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
@@ -0,0 +1,18 @@
+package parser
+
+import "testing"
+
+func TestCRC64(t *testing.T) {
+	// Check value from Redis' crc64.c.
+	const want = 0xe9c6d914c4b8d9ca
+	if got := CRC64(0, []byte("123456789")); got != want {
+		t.Errorf("CRC64(0, %q) = %016x, want %016x", "123456789", got, uint64(want))
+	}
+	// Updates chain, as when checksumming a file as it's read.
+	if got := CRC64(CRC64(0, []byte("1234")), []byte("56789")); got != want {
+		t.Errorf("chained CRC64 = %016x, want %016x", got, uint64(want))
+	}
+	if got := CRC64(0, nil); got != 0 {
+		t.Errorf("CRC64(0, nil) = %016x, want 0", got)
+	}
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// DUMP payloads hold a single value in the RDB format: its value type and
+// encoding, followed by the RDB version it was written with (2 bytes) and a
+// CRC64 checksum of everything before it (8 bytes), both little endian.
+// https://redis.io/docs/latest/commands/dump/
+
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// EncodeDump serializes `v` as a DUMP payload.
+func EncodeDump(v interface{}, compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	w := &RDBWriter{w: &buf, Compress: compress}
+	vt, err := valueType(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.write([]byte{vt}); err != nil {
+		return nil, err
+	}
+	if err := w.writeValue(v); err != nil {
+		return nil, err
+	}
+	footer := make([]byte, 2, 10)
+	binary.LittleEndian.PutUint16(footer, rdbVersion)
+	if err := w.write(footer); err != nil {
+		return nil, err
+	}
+	footer = binary.LittleEndian.AppendUint64(footer[:0], w.crc)
+	buf.Write(footer)
+	return buf.Bytes(), nil
+}
+
+// DecodeDump deserializes a DUMP payload, returning ErrDumpPayload if it's
+// from a newer RDB version or its checksum doesn't match.
+func DecodeDump(p []byte) (interface{}, error) {
+	if len(p) < 10 {
+		return nil, ErrDumpPayload
+	}
+	body, footer := p[:len(p)-10], p[len(p)-10:]
+	if binary.LittleEndian.Uint16(footer) > rdbVersion {
+		return nil, ErrDumpPayload
+	}
+	if CRC64(0, p[:len(p)-8]) != binary.LittleEndian.Uint64(footer[2:]) {
+		return nil, ErrDumpPayload
+	}
+	r := &rdbParser{dbfile: &rdbReader{r: bytes.NewReader(body)}}
+	vt, err := r.readSingleByte()
+	if err != nil {
+		return nil, err
+	}
+	v, err := r.readValue(vt)
+	if err != nil {
+		return nil, fmt.Errorf("bad data format: %w", err)
+	}
+	if r.dbfile.off != int64(len(body)) {
+		return nil, fmt.Errorf("bad data format: %d trailing bytes", int64(len(body))-r.dbfile.off)
+	}
+	return v, nil
+}
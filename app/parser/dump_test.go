@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeDump(t *testing.T) {
+	// DUMP of the string "10" from Redis' DUMP documentation, written with RDB
+	// version 9.
+	const redisDump = "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+	tests := []struct {
+		name    string
+		in      string
+		want    interface{}
+		wantErr error
+	}{
+		{"redis payload", redisDump, []byte("10"), nil},
+		{"bad checksum", redisDump[:len(redisDump)-1] + "\x0b", nil, ErrDumpPayload},
+		{"newer version", "\x00\xc0\n\xff\x00\xbem\x06\x89Z(\x00\n", nil, ErrDumpPayload},
+		{"too short", "\x00\xc0\n", nil, ErrDumpPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeDump([]byte(tt.in))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeDump(%q) error = %v, want %v", tt.in, err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeDump(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	values := []interface{}{
+		[]byte("hello"),
+		[]byte("12345"),
+		[]byte(string(make([]byte, 200))),
+		RDBList(strs("a", "b", "c")),
+		RDBSet(strs("x", "y")),
+		RDBHash{{[]byte("f"), []byte("v")}},
+		RDBSortedSet{{[]byte("m"), 1.5}, {[]byte("n"), -2}},
+	}
+	for _, compress := range []bool{false, true} {
+		for _, v := range values {
+			p, err := EncodeDump(v, compress)
+			if err != nil {
+				t.Fatalf("EncodeDump(%#v, %v) error = %v", v, compress, err)
+			}
+			got, err := DecodeDump(p)
+			if err != nil {
+				t.Fatalf("DecodeDump(EncodeDump(%#v, %v)) error = %v", v, compress, err)
+			}
+			if !reflect.DeepEqual(got, v) {
+				t.Errorf("DecodeDump(EncodeDump(%#v, %v)) = %#v", v, compress, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// LZF, as used by Redis to compress strings in RDB files.
+// http://oldhome.schmorp.de/marc/liblzf.html
+//
+// The compressed stream is a series of chunks, each starting with a control
+// byte:
+//
+//	000LLLLL <L+1 literal bytes>
+//	LLLooooo oooooooo               back reference of length L+2 (L < 7)
+//	111ooooo LLLLLLLL oooooooo      back reference of length L+9
+//
+// where the offset o is one less than the distance back into the output.
+
+const (
+	lzfMaxLit = 1 << 5
+	lzfMaxOff = 1 << 13
+	lzfMaxRef = 1<<8 + 1<<3
+
+	lzfMaxHashLog = 16
+)
+
+// lzfDecompress decompresses `in`, which expands to `outLen` bytes.
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLit { // Literal run
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, fmt.Errorf("lzf: literal run overflows input at offset %d", i-1)
+			}
+			if len(out)+n > outLen {
+				return nil, fmt.Errorf("lzf: output exceeds expected length %d", outLen)
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// Back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("lzf: truncated back reference at offset %d", i-1)
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, fmt.Errorf("lzf: truncated back reference at offset %d", i-1)
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("lzf: back reference before start of output at offset %d", i-2)
+		}
+		if len(out)+n > outLen {
+			return nil, fmt.Errorf("lzf: output exceeds expected length %d", outLen)
+		}
+		// The reference may overlap the bytes being written, so copy one at a
+		// time.
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, fmt.Errorf("lzf: decompressed %d bytes, expected %d", len(out), outLen)
+	}
+	return out, nil
+}
+
+// lzfCompress compresses `in`. It returns nil if the compressed form wouldn't
+// be smaller than `in`.
+func lzfCompress(in []byte) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	out := make([]byte, 0, len(in))
+	// Size the table to the input, since most strings are short and it's
+	// allocated for each one.
+	hashLog := min(bits.Len(uint(len(in))), lzfMaxHashLog)
+	table := make([]int32, 1<<hashLog) // position+1 of the last occurrence of each hash
+	hash := func(i int) uint32 {
+		v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+		return (v * 2654435761) >> (32 - hashLog)
+	}
+
+	lit := 0 // length of the pending literal run, ending at i
+	flushLit := func(end int) {
+		for start := end - lit; start < end; start += lzfMaxLit {
+			n := min(lzfMaxLit, end-start)
+			out = append(out, byte(n-1))
+			out = append(out, in[start:start+n]...)
+		}
+		lit = 0
+	}
+
+	i := 0
+	for i+2 < len(in) {
+		h := hash(i)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		off := i - ref - 1
+		if ref < 0 || off >= lzfMaxOff || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			lit++
+			i++
+			continue
+		}
+		n := 3
+		for n < lzfMaxRef && i+n < len(in) && in[ref+n] == in[i+n] {
+			n++
+		}
+		flushLit(i)
+		if n-2 < 7 {
+			out = append(out, byte((n-2)<<5|off>>8), byte(off))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(n-2-7), byte(off))
+		}
+		i += n
+		if len(out) >= len(in) {
+			return nil
+		}
+	}
+	lit += len(in) - i
+	flushLit(len(in))
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
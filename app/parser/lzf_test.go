@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLZFDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		outLen  int
+		want    string
+		wantErr bool
+	}{
+		{"literal", "\x02abc", 3, "abc", false},
+		{"overlapping reference", "\x02abc\x80\x02", 9, "abcabcabc", false},
+		{"long reference", "\x00a\xe0\x00\x00", 10, "aaaaaaaaaa", false},
+		{"literal overflows input", "\x05abc", 6, "", true},
+		{"reference before start", "\x00a\x20\x05", 4, "", true},
+		{"truncated reference", "\x00a\xe0", 10, "", true},
+		{"longer than expected", "\x02abc", 2, "", true},
+		{"shorter than expected", "\x02abc", 4, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lzfDecompress([]byte(tt.in), tt.outLen)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lzfDecompress(%q, %d) error = %v, wantErr %v", tt.in, tt.outLen, err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("lzfDecompress(%q, %d) = %q, want %q", tt.in, tt.outLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLZFRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		in             string
+		wantCompressed bool
+	}{
+		{"too short", "aaa", false},
+		{"incompressible", "abcdefghijklmnop", false},
+		{"run", strings.Repeat("a", 1000), true},
+		{"repeated text", strings.Repeat("the quick brown fox ", 50), true},
+		{"long literals", strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJ", 3), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := lzfCompress([]byte(tt.in))
+			if (c != nil) != tt.wantCompressed {
+				t.Fatalf("lzfCompress(%q) = %q, want compressed %v", tt.in, c, tt.wantCompressed)
+			}
+			if c == nil {
+				return
+			}
+			if len(c) >= len(tt.in) {
+				t.Errorf("lzfCompress(%q) = %d bytes, not smaller than input", tt.in, len(c))
+			}
+			got, err := lzfDecompress(c, len(tt.in))
+			if err != nil {
+				t.Fatalf("lzfDecompress(lzfCompress(%q)) error = %v", tt.in, err)
+			}
+			if !bytes.Equal(got, []byte(tt.in)) {
+				t.Errorf("lzfDecompress(lzfCompress(%q)) = %q", tt.in, got)
+			}
+		})
+	}
+}
@@ -1,214 +1,388 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"strconv"
 	"time"
 )
 
-type RDBParser = Parser
-
 var (
-	eofFlag = byte(0xFF) // End of the RDB file
-	selFlag = byte(0xFE) // Database Selector
-	exsFlag = byte(0xFD) // Expire time in seconds, see Key Expiry Timestamp
-	exmFlag = byte(0xFC) // Expire time in milliseconds, see Key Expiry Timestamp
-	htsFlag = byte(0xFB) // Hash table sizes for the main keyspace and expires, see Resizedb information
-	auxFlag = byte(0xFA) // Auxiliary fields. Arbitrary key-value settings, see Auxiliary fields
+	slotFlag = byte(0xF4) // Slot info (Introduced in Redis 7.4); skipped
+	fn2Flag  = byte(0xF5) // Function library (Introduced in RDB version 10)
+	fn1Flag  = byte(0xF6) // Function, pre-GA format; unsupported
+	modFlag  = byte(0xF7) // Module auxiliary data (Introduced in RDB version 9)
+	idlFlag  = byte(0xF8) // LRU idle time of the next key (Introduced in RDB version 9)
+	frqFlag  = byte(0xF9) // LFU frequency of the next key (Introduced in RDB version 9)
+	eofFlag  = byte(0xFF) // End of the RDB file
+	selFlag  = byte(0xFE) // Database Selector
+	exsFlag  = byte(0xFD) // Expire time in seconds, see Key Expiry Timestamp
+	exmFlag  = byte(0xFC) // Expire time in milliseconds, see Key Expiry Timestamp
+	htsFlag  = byte(0xFB) // Hash table sizes for the main keyspace and expires, see Resizedb information
+	auxFlag  = byte(0xFA) // Auxiliary fields. Arbitrary key-value settings, see Auxiliary fields
 )
 
+// Largest string the RDB and RESP parsers will allocate, matching Redis'
+// default proto-max-bulk-len. Guards against corrupt or hostile lengths.
+const maxStringLen = 512 << 20
+
+// Largest number of collection elements preallocated up front; collections
+// claiming to be larger grow as they're read.
+const maxPrealloc = 1 << 10
+
 type rdbParser struct {
-	dbfile io.Reader
+	dbfile *rdbReader
+	info   RDBInfo
+	op     byte // Op code or value type currently being processed
+	buf    [8]byte
+	// LRU/LFU info for the next key, if any
+	idle, freq       int
+	hasIdle, hasFreq bool
+}
+
+// RDBInfo holds everything in an RDB file other than its key/value pairs.
+type RDBInfo struct {
+	Version int
+	// Auxiliary fields, like "repl-id" and "repl-offset".
+	Aux map[string]string
+	// Function library code, which isn't otherwise loaded.
+	Functions [][]byte
+	// Names of modules whose auxiliary data was skipped.
+	Modules []string
+}
+
+// ErrRDBChecksum is wrapped by the RDBParseError returned when an RDB file's
+// checksum doesn't match its contents.
+var ErrRDBChecksum = errors.New("checksum mismatch")
+
+// RDBParseError describes where parsing an RDB file failed.
+type RDBParseError struct {
+	Offset int64 // Offset into the file at which parsing failed
+	Opcode byte  // Op code or value type being processed; zero in the header
+	Err    error
+}
+
+func (e *RDBParseError) Error() string {
+	return fmt.Sprintf("rdb parse error at offset %d (op code %#x): %v", e.Offset, e.Opcode, e.Err)
+}
+
+func (e *RDBParseError) Unwrap() error {
+	return e.Err
+}
+
+// rdbReader tracks the offset and running checksum of everything read.
+type rdbReader struct {
+	r   io.Reader
+	off int64
+	crc uint64
 }
 
-func NewRDBParser(dbfile io.Reader) RDBParser {
-	return &rdbParser{dbfile}
+func (r *rdbReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.crc = CRC64(r.crc, p[:n])
+	r.off += int64(n)
+	return n, err
 }
 
-func (r *rdbParser) Parse() ParseResponse {
+// ParseRDB parses the RDB file read from `dbfile`, calling `fn` with each
+// entry as it's read and verifying the file's checksum at the end. Parsing
+// stops at the first error from `fn`, which is returned as is; errors in the
+// file itself are returned as an *RDBParseError.
+func ParseRDB(dbfile io.Reader, fn func(RDBEntry) error) (RDBInfo, error) {
+	r := &rdbParser{dbfile: &rdbReader{r: dbfile}, info: RDBInfo{Aux: make(map[string]string)}}
+	var fnErr error
+	err := r.parse(func(e RDBEntry) error {
+		fnErr = fn(e)
+		return fnErr
+	})
+	if err == nil || (fnErr != nil && err == fnErr) {
+		return r.info, err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return r.info, &RDBParseError{Offset: r.dbfile.off, Opcode: r.op, Err: err}
+}
+
+func (r *rdbParser) parse(fn func(RDBEntry) error) error {
 	// Header section
 	// Parse magic string & version -- 9 bytes
 	headerBuf := make([]byte, 9)
-	n, err := r.dbfile.Read(headerBuf)
-	if err != nil {
-		log.Println("[RDBParser] Error reading header: ", err.Error())
-		return nil
-	}
-	if n != 9 {
-		log.Println("[RDBParser] Incorrect number of header bytes read: ", n)
-		return nil
+	if _, err := io.ReadFull(r.dbfile, headerBuf); err != nil {
+		return fmt.Errorf("reading header: %w", err)
 	}
 
 	// Assert magic string
 	if ms := string(headerBuf[:5]); ms != "REDIS" {
-		log.Println("[RDBParser] Improper magic string: ", ms)
-		return nil
+		return fmt.Errorf("improper magic string: %q", ms)
 	}
 	// Parse version
 	version, err := strconv.Atoi(string(headerBuf[5:]))
 	if err != nil {
-		log.Println("[RDBParser] Error parsing version: ", err.Error())
-		return nil
+		return fmt.Errorf("parsing version: %w", err)
 	}
+	r.info.Version = version
 	log.Println("[RDBParser] Version: ", version)
 
-	// Parse sections
-	// Extract OpCode from first bit
-	oc, err := r.readSingleByte()
-	if err != nil {
-		log.Println("[RDBParser] Error reading first op code: ", err.Error())
-		return nil
+	// Process op codes until EOF; anything that isn't an op code is the value
+	// type of a key/value pair.
+	for {
+		oc, err := r.readSingleByte()
+		if err != nil {
+			return err
+		}
+		r.op = oc
+		switch oc {
+		case eofFlag:
+			return r.readChecksum()
+		case auxFlag:
+			// Metadata; read two strings
+			s1, err := r.readStringEncoding()
+			if err != nil {
+				return err
+			}
+			s2, err := r.readStringEncoding()
+			if err != nil {
+				return err
+			}
+			log.Printf("[RDBParser] Metadata %q = %q\n", s1, s2)
+			r.info.Aux[string(s1)] = string(s2)
+		case selFlag:
+			// Data section; read size encoded db index.
+			idx, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			// Log idx; we otherwise ignore it for now.
+			log.Printf("[RDBParser] Database index %d\n", idx)
+		case htsFlag:
+			// Read two size encoded integers.
+			dbHashTableSize, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			expiryHashTableSize, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			// Log hash table sizes; otherwise ignore for now.
+			log.Printf("[RDBParser] dbhts: %d; ehts: %d\n", dbHashTableSize, expiryHashTableSize)
+		case slotFlag:
+			// Slot id, and the slot's hash table sizes; only used by cluster
+			// mode.
+			for i := 0; i < 3; i++ {
+				if _, err := r.readLength(); err != nil {
+					return err
+				}
+			}
+		case fn2Flag:
+			// Function library code; retained, but not loaded.
+			lib, err := r.readStringEncoding()
+			if err != nil {
+				return err
+			}
+			r.info.Functions = append(r.info.Functions, lib)
+		case fn1Flag:
+			return fmt.Errorf("pre-release function format not supported")
+		case modFlag:
+			name, err := r.skipModuleAux()
+			if err != nil {
+				return err
+			}
+			log.Printf("[RDBParser] Skipping auxiliary data for unknown module %q\n", name)
+			r.info.Modules = append(r.info.Modules, name)
+		case idlFlag:
+			// LRU idle time of the next key, in seconds.
+			idle, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			r.idle, r.hasIdle = idle, true
+		case frqFlag:
+			// LFU frequency of the next key.
+			freq, err := r.readSingleByte()
+			if err != nil {
+				return err
+			}
+			r.freq, r.hasFreq = int(freq), true
+		case exmFlag:
+			// Read expiry time in ms; 8 byte unsigned long, little endian
+			if _, err := io.ReadFull(r.dbfile, r.buf[:8]); err != nil {
+				return err
+			}
+			expiry := time.UnixMilli(int64(binary.LittleEndian.Uint64(r.buf[:8])))
+			if err := r.readEntry(expiry, fn); err != nil {
+				return err
+			}
+		case exsFlag:
+			// Read expiry time in s; 4 byte unsigned int, little endian
+			if _, err := io.ReadFull(r.dbfile, r.buf[:4]); err != nil {
+				return err
+			}
+			expiry := time.Unix(int64(binary.LittleEndian.Uint32(r.buf[:4])), 0)
+			if err := r.readEntry(expiry, fn); err != nil {
+				return err
+			}
+		default: // No expiry, process k/v pair
+			if err := r.processEntry(oc, time.Time{}, fn); err != nil {
+				return err
+			}
+		}
 	}
+}
 
-	data, err := r.processOpCode(oc, [][]interface{}{})
-	if err != nil && err != io.EOF {
-		log.Println("[RDBParser] Error parsing file: ", err.Error())
+// Reads the checksum following the EOF op code. Files from version 5 on end
+// with an 8 byte CRC64 checksum, little endian, of everything before it. A
+// zero checksum means checksums were disabled when writing.
+func (r *rdbParser) readChecksum() error {
+	if r.info.Version < 5 {
 		return nil
 	}
-
-	return data
+	crc := r.dbfile.crc
+	if _, err := io.ReadFull(r.dbfile, r.buf[:8]); err != nil {
+		return err
+	}
+	checksum := binary.LittleEndian.Uint64(r.buf[:8])
+	if checksum != 0 && checksum != crc {
+		return fmt.Errorf("%w: file has %016x, computed %016x", ErrRDBChecksum, checksum, crc)
+	}
+	return nil
 }
 
-// Recursively process opCode and subsequent data until EOF or error; returns
-// list of k/v with optional expiry -- k, v[, e]
-func (r *rdbParser) processOpCode(code byte, data [][]interface{}) ([][]interface{}, error) {
-	switch code {
-	case eofFlag:
-		// We've reached the end of the file
-		// Discard 8 byte checksum
-		buf := make([]byte, 8)
-		if _, err := r.dbfile.Read(buf); err != nil {
-			return data, err
-		}
-		return data, nil
-	case auxFlag:
-		// Metadata; read two strings
-		s1, err := r.readStringEncoding()
-		if err != nil {
-			return data, err
-		}
-		s2, err := r.readStringEncoding()
-		if err != nil {
-			return data, err
-		}
-		// Log metadata; we otherwise ignore it for now.
-		log.Printf("[RDBParser] Metadata %q = %q\n", s1, s2)
-		// Read next opCode
-		oc, err := r.readSingleByte()
-		if err != nil {
-			return data, err
-		}
-		return r.processOpCode(oc, data)
-	case selFlag:
-		// Data section; read size encoded db index.
-		idx, _, err := r.readSizeEncoding()
-		if err != nil {
-			return data, err
-		}
-		// Log idx; we otherwise ignore it for now.
-		log.Printf("[RDBParser] Database index %d\n", idx)
-
-		// Read next op code.
-		oc, err := r.readSingleByte()
-		if err != nil {
-			return data, err
-		}
-		// Assert next op code is htsFlag.
-		if oc != htsFlag {
-			return data, fmt.Errorf("expected %#v op code, received %#v", htsFlag, oc)
-		}
-		// Read two size encoded integers.
-		dbHashTableSize, _, err := r.readSizeEncoding()
-		if err != nil {
-			return data, err
-		}
-		expiryHashTableSize, _, err := r.readSizeEncoding()
-		if err != nil {
-			return data, err
-		}
-		// Log hash table sizes; otherwise ignore for now.
-		log.Printf("[RDBParser] dbhts: %d; ehts: %d\n", dbHashTableSize, expiryHashTableSize)
+// Reads the value type of a key/value pair following its expiry, then the
+// pair itself.
+func (r *rdbParser) readEntry(expiry time.Time, fn func(RDBEntry) error) error {
+	vt, err := r.readSingleByte()
+	if err != nil {
+		return err
+	}
+	r.op = vt
+	return r.processEntry(vt, expiry, fn)
+}
 
-		// Process data section
-		nextOc, kvs, err := r.processValues([][]interface{}{})
-		if err != nil {
-			return data, err
-		}
-		return r.processOpCode(nextOc, append(data, kvs[:]...))
-	default:
-		return data, fmt.Errorf("unrecognized op code '%#v'", code)
+// Reads a key/value pair with value type `vt`, and passes it to `fn`.
+func (r *rdbParser) processEntry(vt byte, expiry time.Time, fn func(RDBEntry) error) error {
+	// Read string encoded key
+	key, err := r.readStringEncoding()
+	if err != nil {
+		return err
+	}
+	val, err := r.readValue(vt)
+	if err != nil {
+		return err
+	}
+	e := RDBEntry{Key: key, Value: val, Expiry: expiry}
+	if r.hasIdle {
+		e.Idle, e.HasIdle = time.Duration(r.idle)*time.Second, true
 	}
+	if r.hasFreq {
+		e.Freq, e.HasFreq = uint8(r.freq), true
+	}
+	r.hasIdle, r.hasFreq = false, false
+	return fn(e)
 }
 
-// Reads key/value pairs from database section with optional expiry.
-// [["key", "val"], ["key", "val", "expiry"]]
-func (r *rdbParser) processValues(data [][]interface{}) (byte, [][]interface{}, error) {
-	// Initialize optional expiry
-	var expiry time.Time
-	var vt byte
+// Module value opcodes, each followed by a value of that type.
+const (
+	moduleOpEOF    = 0
+	moduleOpSInt   = 1
+	moduleOpUInt   = 2
+	moduleOpFloat  = 3
+	moduleOpDouble = 4
+	moduleOpString = 5
+)
 
-	// Read first byte
-	fb, err := r.readSingleByte()
+// Skips a module's auxiliary data, returning the module's name. There's no
+// module to hand the data to, but its serialized values are self-describing,
+// so it can be skipped over.
+func (r *rdbParser) skipModuleAux() (string, error) {
+	id, err := r.readUint64()
 	if err != nil {
-		return byte(0), data, err
-	}
-	switch fb {
-	case selFlag, eofFlag:
-		// Finished reading this db; return op code & data.
-		return fb, data, nil
-	case exmFlag:
-		// Read expiry time in ms; 8 byte unsigned long, little endian
-		var exp int64
-		if err := binary.Read(r.dbfile, binary.LittleEndian, &exp); err != nil {
-			return byte(0), data, err
+		return "", err
+	}
+	// When opcode and when (before or after the keyspace).
+	for i := 0; i < 2; i++ {
+		if _, err := r.readLength(); err != nil {
+			return "", err
 		}
-		expiry = time.UnixMilli(exp)
-		vt, err = r.readSingleByte()
+	}
+	for {
+		op, err := r.readLength()
 		if err != nil {
-			return byte(0), data, err
+			return "", err
 		}
-	case exsFlag:
-		// Read expiry time in s; 4 byte unsigned int, little endian
-		var exp int32
-		if err := binary.Read(r.dbfile, binary.LittleEndian, &exp); err != nil {
-			return byte(0), data, err
+		switch op {
+		case moduleOpEOF:
+			return moduleName(id), nil
+		case moduleOpSInt, moduleOpUInt:
+			_, err = r.readUint64()
+		case moduleOpFloat:
+			_, err = io.ReadFull(r.dbfile, r.buf[:4])
+		case moduleOpDouble:
+			_, err = io.ReadFull(r.dbfile, r.buf[:8])
+		case moduleOpString:
+			_, err = r.readStringEncoding()
+		default:
+			err = fmt.Errorf("unrecognized module value opcode: %d", op)
 		}
-		expiry = time.Unix(int64(exp), 0)
-		vt, err = r.readSingleByte()
 		if err != nil {
-			return byte(0), data, err
+			return "", err
 		}
-	default: // No expiry, process k/v pair
-		vt = fb
 	}
+}
 
-	// Read string encoded key
-	key, err := r.readStringEncoding()
-	if err != nil {
-		return byte(0), data, err
-	}
-	val, err := r.readValue(vt)
-	if err != nil {
-		return byte(0), data, err
-	}
-	// Return k/v with optional expiry
-	if !expiry.IsZero() {
-		return r.processValues(append(data, []interface{}{key, val, expiry}))
+// Decodes a module's name from its id: nine 6 bit characters, followed by a
+// 10 bit encoding version.
+func moduleName(id uint64) string {
+	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	name := make([]byte, 9)
+	for i := range name {
+		name[i] = charset[(id>>(64-6*(i+1)))&0x3F]
 	}
-	return r.processValues(append(data, []interface{}{key, val}))
+	return string(name)
 }
 
 // Reads a single byte.
 func (r *rdbParser) readSingleByte() (byte, error) {
-	buf := make([]byte, 1)
-	if _, err := r.dbfile.Read(buf); err != nil {
+	if _, err := io.ReadFull(r.dbfile, r.buf[:1]); err != nil {
 		return byte(0), err
 	}
-	return buf[0], nil
+	return r.buf[0], nil
+}
+
+// Reads a size encoded length, which mustn't be a string formatted integer.
+func (r *rdbParser) readLength() (int, error) {
+	size, isString, err := r.readSizeEncoding()
+	if err != nil {
+		return 0, err
+	}
+	if isString {
+		return 0, fmt.Errorf("expected length, found integer string encoding")
+	}
+	return size, nil
+}
+
+// Reads a size encoded unsigned 64 bit integer, which may not fit an int.
+func (r *rdbParser) readUint64() (uint64, error) {
+	b, err := r.readSingleByte()
+	if err != nil {
+		return 0, err
+	}
+	if b == 0x81 {
+		if _, err := io.ReadFull(r.dbfile, r.buf[:8]); err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(r.buf[:8]), nil
+	}
+	size, isString, err := r.decodeSizeEncoding(b)
+	if err == nil && isString {
+		err = fmt.Errorf("expected length, found integer string encoding")
+	}
+	return uint64(size), err
 }
 
 // Reads size encoding from next byte.
@@ -220,6 +394,12 @@ func (r *rdbParser) readSizeEncoding() (size int, isString bool, err error) {
 	if err != nil {
 		return
 	}
+	return r.decodeSizeEncoding(b)
+}
+
+// Decodes the size encoding starting with flag byte `b`. Lengths are
+// unsigned; string formatted integers are signed.
+func (r *rdbParser) decodeSizeEncoding(b byte) (size int, isString bool, err error) {
 	// Switch on two most significant bits
 	switch b >> 6 {
 	case 0b11: // String formatting
@@ -227,54 +407,59 @@ func (r *rdbParser) readSizeEncoding() (size int, isString bool, err error) {
 		// Switch on the last six bits of the flag byte.
 		switch b & 0b00111111 {
 		case 0b0: // 8 bit integer.
-			var val int8
-			if err = binary.Read(r.dbfile, binary.LittleEndian, &val); err != nil {
-				return
-			}
-			size = int(val)
+			var val byte
+			val, err = r.readSingleByte()
+			size = int(int8(val))
 		case 0b1: // 16 bit integer
-			var val int16
-			if err = binary.Read(r.dbfile, binary.LittleEndian, &val); err != nil {
-				return
+			if _, err = io.ReadFull(r.dbfile, r.buf[:2]); err == nil {
+				size = int(int16(binary.LittleEndian.Uint16(r.buf[:2])))
 			}
-			size = int(val)
 		case 0b10: // 32 bit integer.
-			var val int32
-			if err = binary.Read(r.dbfile, binary.LittleEndian, &val); err != nil {
-				return
+			if _, err = io.ReadFull(r.dbfile, r.buf[:4]); err == nil {
+				size = int(int32(binary.LittleEndian.Uint32(r.buf[:4])))
 			}
-			size = int(val)
 		default:
 			err = fmt.Errorf("unrecognized integer string encoding: %#b", b&0b00111111)
-			return
 		}
-	case 0b10: // Size is in next 4 bytes (32 bits)
-		var val int32
-		if err = binary.Read(r.dbfile, binary.LittleEndian, &val); err != nil {
-			return
+	case 0b10:
+		switch b {
+		case 0x80: // Size is in next 4 bytes (32 bits), big endian
+			if _, err = io.ReadFull(r.dbfile, r.buf[:4]); err == nil {
+				size = int(binary.BigEndian.Uint32(r.buf[:4]))
+			}
+		case 0x81: // Size is in next 8 bytes (64 bits), big endian
+			if _, err = io.ReadFull(r.dbfile, r.buf[:8]); err == nil {
+				val := binary.BigEndian.Uint64(r.buf[:8])
+				if val > math.MaxInt {
+					err = fmt.Errorf("length out of range: %d", val)
+				}
+				size = int(val)
+			}
+		default:
+			err = fmt.Errorf("unrecognized size encoding: %#x", b)
 		}
-		size = int(val)
 	case 0b1: // Size is in remaining 6 bits plus next byte
 		var nb byte
 		nb, err = r.readSingleByte()
-		if err != nil {
-			return
-		}
-		sizeRaw := []byte{byte(b & 0b00111111), nb}
-		reader := bytes.NewReader(sizeRaw)
-		var val int16
-		if err = binary.Read(reader, binary.LittleEndian, &val); err != nil {
-			return
-		}
-		size = int(val)
+		size = int(b&0b00111111)<<8 | int(nb)
 	default:
 		size = int(b)
 	}
 	return
 }
 
+// Flag byte for an LZF compressed string; string formatting with value 3.
+const lzfFlag = byte(0b11000011)
+
 func (r *rdbParser) readStringEncoding() ([]byte, error) {
-	size, isString, err := r.readSizeEncoding()
+	b, err := r.readSingleByte()
+	if err != nil {
+		return []byte{}, err
+	}
+	if b == lzfFlag {
+		return r.readLZFString()
+	}
+	size, isString, err := r.decodeSizeEncoding(b)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -282,40 +467,285 @@ func (r *rdbParser) readStringEncoding() ([]byte, error) {
 		// Return string formatted integer
 		return []byte(strconv.Itoa(size)), nil
 	}
-	// Read string of `size`
-	strBuf := make([]byte, int(size))
-	if _, err := r.dbfile.Read(strBuf); err != nil {
+	return r.readBytes(size)
+}
+
+// Reads a string of `size` bytes.
+func (r *rdbParser) readBytes(size int) ([]byte, error) {
+	if size > maxStringLen {
+		return nil, fmt.Errorf("string length %d exceeds maximum %d", size, maxStringLen)
+	}
+	strBuf := make([]byte, size)
+	if _, err := io.ReadFull(r.dbfile, strBuf); err != nil {
 		return []byte{}, err
 	}
 	return strBuf, nil
 }
 
-func (r *rdbParser) readValue(vt byte) ([]byte, error) {
+// Reads an LZF compressed string: its compressed and uncompressed lengths,
+// followed by the compressed data.
+func (r *rdbParser) readLZFString() ([]byte, error) {
+	clen, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	ulen, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if ulen > maxStringLen {
+		return nil, fmt.Errorf("string length %d exceeds maximum %d", ulen, maxStringLen)
+	}
+	compressed, err := r.readBytes(clen)
+	if err != nil {
+		return nil, err
+	}
+	return lzfDecompress(compressed, ulen)
+}
+
+// RDBEntry is a key/value pair from a database, with an optional expiry.
+//
+// Value holds a string value as []byte, or one of the RDB collection types.
+type RDBEntry struct {
+	Key    []byte
+	Value  interface{}
+	Expiry time.Time
+	// LRU idle time and LFU frequency, if the file has them.
+	Idle             time.Duration
+	Freq             uint8
+	HasIdle, HasFreq bool
+}
+
+// Collection values returned by the parser. String values are returned as
+// []byte.
+type (
+	RDBList      [][]byte
+	RDBSet       [][]byte
+	RDBSortedSet []RDBSortedSetEntry
+	RDBHash      []RDBHashEntry
+)
+
+type RDBSortedSetEntry struct {
+	Member []byte
+	Score  float64
+}
+
+type RDBHashEntry struct {
+	Field []byte
+	Value []byte
+}
+
+func (r *rdbParser) readValue(vt byte) (interface{}, error) {
 	switch vt {
-	case 0x0: // String Encoding
+	case 0: // String Encoding
 		return r.readStringEncoding()
-	case 0x1: // List Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: List")
-	case 0x2: // Set Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Set")
-	case 0x3: // Sorted Set Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Sorted Set")
-	case 0x4: // Hash Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Hash")
-	case 0x9: // Zipmap Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Zipmap")
-	case 0x10: // Ziplist Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Ziplist")
-	case 0x11: // Intset Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Intset")
-	case 0x12: // Sorted Set in Ziplist Encoding
-		return []byte{}, fmt.Errorf("unimplemented encoding: Sorted Set in Ziplist")
-	case 0x13: // Hashmap in Ziplist Encoding (Introduced in RDB version 4)
-		return []byte{}, fmt.Errorf("unimplemented encoding: Hashmap in Ziplist")
-	case 0x14: // List in Quicklist encoding (Introduced in RDB version 7)
-		return []byte{}, fmt.Errorf("unimplemented encoding: List in Quicklist")
-
+	case 1: // List Encoding
+		elems, err := r.readStrings()
+		return RDBList(elems), err
+	case 2: // Set Encoding
+		elems, err := r.readStrings()
+		return RDBSet(elems), err
+	case 3: // Sorted Set Encoding
+		return r.readSortedSet(r.readStringDouble)
+	case 4: // Hash Encoding
+		return r.readHash()
+	case 5: // Sorted Set Encoding, with binary doubles (Introduced in RDB version 8)
+		return r.readSortedSet(r.readBinaryDouble)
+	case 9: // Zipmap Encoding
+		elems, err := r.readEncoded(decodeZipmap)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(elems)
+	case 10: // List in Ziplist Encoding
+		elems, err := r.readEncoded(decodeZiplist)
+		return RDBList(elems), err
+	case 11: // Intset Encoding
+		elems, err := r.readEncoded(decodeIntset)
+		return RDBSet(elems), err
+	case 12: // Sorted Set in Ziplist Encoding
+		elems, err := r.readEncoded(decodeZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToSortedSet(elems)
+	case 13: // Hashmap in Ziplist Encoding (Introduced in RDB version 4)
+		elems, err := r.readEncoded(decodeZiplist)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(elems)
+	case 14: // List in Quicklist Encoding (Introduced in RDB version 7)
+		return r.readQuicklist(false)
+	case 16: // Hashmap in Listpack Encoding (Introduced in RDB version 10)
+		elems, err := r.readEncoded(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(elems)
+	case 17: // Sorted Set in Listpack Encoding (Introduced in RDB version 10)
+		elems, err := r.readEncoded(decodeListpack)
+		if err != nil {
+			return nil, err
+		}
+		return pairsToSortedSet(elems)
+	case 18: // List in Quicklist Encoding, with listpack nodes (Introduced in RDB version 10)
+		return r.readQuicklist(true)
+	case 20: // Set in Listpack Encoding (Introduced in RDB version 11)
+		elems, err := r.readEncoded(decodeListpack)
+		return RDBSet(elems), err
+	case 6, 7: // Module Encoding
+		return nil, fmt.Errorf("unsupported encoding: Module")
+	case 15, 19, 21: // Stream Encoding
+		return nil, fmt.Errorf("unsupported encoding: Stream")
 	default:
-		return []byte{}, fmt.Errorf("unrecognized value type: %#v", vt)
+		return nil, fmt.Errorf("unrecognized value type: %#v", vt)
+	}
+}
+
+// Reads a string blob and decodes it with `decode`.
+func (r *rdbParser) readEncoded(decode func([]byte) ([][]byte, error)) ([][]byte, error) {
+	p, err := r.readStringEncoding()
+	if err != nil {
+		return nil, err
+	}
+	return decode(p)
+}
+
+// Quicklist node containers (Introduced in RDB version 10)
+const (
+	quicklistNodePlain  = 1 // A single element, stored as a plain string
+	quicklistNodePacked = 2 // A listpack of elements
+)
+
+// Reads a list stored as a size encoded number of nodes. Nodes are ziplists
+// or, when `v2` is set, listpacks or plain strings.
+func (r *rdbParser) readQuicklist(v2 bool) (RDBList, error) {
+	size, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var list RDBList
+	for i := 0; i < size; i++ {
+		if !v2 {
+			elems, err := r.readEncoded(decodeZiplist)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elems...)
+			continue
+		}
+		container, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		switch container {
+		case quicklistNodePlain:
+			elem, err := r.readStringEncoding()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elem)
+		case quicklistNodePacked:
+			elems, err := r.readEncoded(decodeListpack)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elems...)
+		default:
+			return nil, fmt.Errorf("unrecognized quicklist node container: %d", container)
+		}
+	}
+	return list, nil
+}
+
+// Reads a size encoded number of strings.
+func (r *rdbParser) readStrings() ([][]byte, error) {
+	size, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	elems := make([][]byte, 0, min(size, maxPrealloc))
+	for i := 0; i < size; i++ {
+		elem, err := r.readStringEncoding()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// Reads a size encoded number of field/value pairs.
+func (r *rdbParser) readHash() (RDBHash, error) {
+	size, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := make(RDBHash, 0, min(size, maxPrealloc))
+	for i := 0; i < size; i++ {
+		field, err := r.readStringEncoding()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.readStringEncoding()
+		if err != nil {
+			return nil, err
+		}
+		hash = append(hash, RDBHashEntry{field, value})
+	}
+	return hash, nil
+}
+
+// Reads a size encoded number of member/score pairs, using `readScore` to
+// read each score.
+func (r *rdbParser) readSortedSet(readScore func() (float64, error)) (RDBSortedSet, error) {
+	size, err := r.readLength()
+	if err != nil {
+		return nil, err
+	}
+	zset := make(RDBSortedSet, 0, min(size, maxPrealloc))
+	for i := 0; i < size; i++ {
+		member, err := r.readStringEncoding()
+		if err != nil {
+			return nil, err
+		}
+		score, err := readScore()
+		if err != nil {
+			return nil, err
+		}
+		zset = append(zset, RDBSortedSetEntry{member, score})
+	}
+	return zset, nil
+}
+
+// Reads a double stored as a string, prefixed by its length in a single byte.
+// Lengths 253-255 are reserved for NaN, +Inf, and -Inf.
+func (r *rdbParser) readStringDouble() (float64, error) {
+	l, err := r.readSingleByte()
+	if err != nil {
+		return 0, err
+	}
+	switch l {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(r.dbfile, buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// Reads an 8 byte IEEE 754 double, little endian.
+func (r *rdbParser) readBinaryDouble() (float64, error) {
+	var bits uint64
+	if err := binary.Read(r.dbfile, binary.LittleEndian, &bits); err != nil {
+		return 0, err
 	}
+	return math.Float64frombits(bits), nil
 }
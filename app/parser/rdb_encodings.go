@@ -0,0 +1,329 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Decoders for the compact encodings Redis uses to store small collections.
+// Each is stored in the RDB file as a single string blob, which is decoded into
+// a flat list of elements; for hashes and sorted sets these alternate between
+// field (or member) and value (or score).
+
+// blob reads from an encoded string blob, failing on out-of-bounds reads.
+type blob struct {
+	b   []byte
+	pos int
+	enc string // encoding name, for errors
+}
+
+func (b *blob) next(n int) ([]byte, error) {
+	if n < 0 || n > len(b.b)-b.pos {
+		return nil, fmt.Errorf("%s: unexpected end of data at offset %d", b.enc, b.pos)
+	}
+	p := b.b[b.pos : b.pos+n]
+	b.pos += n
+	return p, nil
+}
+
+func (b *blob) readByte() (byte, error) {
+	p, err := b.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// intBytes formats an integer element the way Redis returns it to clients.
+func intBytes(i int64) []byte {
+	return []byte(strconv.FormatInt(i, 10))
+}
+
+// signExtend interprets the low `bits` bits of `v` as a two's complement
+// integer.
+func signExtend(v uint64, bits uint) int64 {
+	shift := 64 - bits
+	return int64(v<<shift) >> shift
+}
+
+// Decodes a ziplist.
+// https://github.com/redis/redis/blob/7.2/src/ziplist.c
+//
+//	<zlbytes uint32> <zltail uint32> <zllen uint16> <entry>... <0xFF>
+//	entry: <prevlen> <encoding> <data>
+func decodeZiplist(p []byte) ([][]byte, error) {
+	b := &blob{b: p, enc: "ziplist"}
+	header, err := b.next(10)
+	if err != nil {
+		return nil, err
+	}
+	if n := binary.LittleEndian.Uint32(header); int(n) != len(p) {
+		return nil, fmt.Errorf("ziplist: header length %d doesn't match blob length %d", n, len(p))
+	}
+	var elems [][]byte
+	for {
+		prevlen, err := b.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if prevlen == 0xFF {
+			return elems, nil
+		}
+		if prevlen == 0xFE {
+			if _, err := b.next(4); err != nil {
+				return nil, err
+			}
+		}
+		enc, err := b.readByte()
+		if err != nil {
+			return nil, err
+		}
+		var elem []byte
+		switch {
+		case enc>>6 == 0b00: // String, length in remaining 6 bits
+			elem, err = b.next(int(enc & 0x3F))
+		case enc>>6 == 0b01: // String, length in remaining 6 bits plus next byte, big endian
+			var lo byte
+			if lo, err = b.readByte(); err == nil {
+				elem, err = b.next(int(enc&0x3F)<<8 | int(lo))
+			}
+		case enc == 0x80: // String, length in next 4 bytes, big endian
+			var l []byte
+			if l, err = b.next(4); err == nil {
+				elem, err = b.next(int(binary.BigEndian.Uint32(l)))
+			}
+		case enc == 0xC0: // int16
+			elem, err = b.int(2)
+		case enc == 0xD0: // int32
+			elem, err = b.int(4)
+		case enc == 0xE0: // int64
+			elem, err = b.int(8)
+		case enc == 0xF0: // int24
+			elem, err = b.int(3)
+		case enc == 0xFE: // int8
+			elem, err = b.int(1)
+		case enc >= 0xF1 && enc <= 0xFD: // 4 bit immediate, 0 - 12
+			elem = intBytes(int64(enc&0x0F) - 1)
+		default:
+			err = fmt.Errorf("ziplist: unrecognized entry encoding %#x at offset %d", enc, b.pos-1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+}
+
+// Reads a little endian signed integer of `n` bytes.
+func (b *blob) int(n int) ([]byte, error) {
+	p, err := b.next(n)
+	if err != nil {
+		return nil, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[i])
+	}
+	return intBytes(signExtend(v, uint(n*8))), nil
+}
+
+// Decodes a listpack.
+// https://github.com/antirez/listpack/blob/master/listpack.md
+//
+//	<total bytes uint32> <num elements uint16> <element>... <0xFF>
+//	element: <encoding> <data> <backlen>
+func decodeListpack(p []byte) ([][]byte, error) {
+	b := &blob{b: p, enc: "listpack"}
+	header, err := b.next(6)
+	if err != nil {
+		return nil, err
+	}
+	if n := binary.LittleEndian.Uint32(header); int(n) != len(p) {
+		return nil, fmt.Errorf("listpack: header length %d doesn't match blob length %d", n, len(p))
+	}
+	var elems [][]byte
+	for {
+		start := b.pos
+		enc, err := b.readByte()
+		if err != nil {
+			return nil, err
+		}
+		var elem []byte
+		switch {
+		case enc == 0xFF: // End of listpack
+			return elems, nil
+		case enc>>7 == 0b0: // 7 bit unsigned int
+			elem = intBytes(int64(enc))
+		case enc>>6 == 0b10: // String, length in remaining 6 bits
+			elem, err = b.next(int(enc & 0x3F))
+		case enc>>5 == 0b110: // 13 bit signed int
+			var lo byte
+			if lo, err = b.readByte(); err == nil {
+				elem = intBytes(signExtend(uint64(enc&0x1F)<<8|uint64(lo), 13))
+			}
+		case enc>>4 == 0b1110: // String, length in remaining 4 bits plus next byte
+			var lo byte
+			if lo, err = b.readByte(); err == nil {
+				elem, err = b.next(int(enc&0x0F)<<8 | int(lo))
+			}
+		case enc == 0xF0: // String, length in next 4 bytes, little endian
+			var l []byte
+			if l, err = b.next(4); err == nil {
+				elem, err = b.next(int(binary.LittleEndian.Uint32(l)))
+			}
+		case enc == 0xF1: // int16
+			elem, err = b.int(2)
+		case enc == 0xF2: // int24
+			elem, err = b.int(3)
+		case enc == 0xF3: // int32
+			elem, err = b.int(4)
+		case enc == 0xF4: // int64
+			elem, err = b.int(8)
+		default:
+			err = fmt.Errorf("listpack: unrecognized element encoding %#x at offset %d", enc, start)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Skip backlen, which holds the length of the encoding and data.
+		if _, err := b.next(backlenSize(b.pos - start)); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+}
+
+// Returns the number of bytes used to store a listpack element's backlen.
+func backlenSize(l int) int {
+	switch {
+	case l < 1<<7:
+		return 1
+	case l < 1<<14:
+		return 2
+	case l < 1<<21:
+		return 3
+	case l < 1<<28:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// Decodes an intset.
+// https://github.com/redis/redis/blob/7.2/src/intset.c
+//
+//	<encoding uint32> <length uint32> <int>...
+func decodeIntset(p []byte) ([][]byte, error) {
+	b := &blob{b: p, enc: "intset"}
+	header, err := b.next(8)
+	if err != nil {
+		return nil, err
+	}
+	width := binary.LittleEndian.Uint32(header)
+	if width != 2 && width != 4 && width != 8 {
+		return nil, fmt.Errorf("intset: unrecognized encoding %d", width)
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if uint64(length)*uint64(width) != uint64(len(p)-8) {
+		return nil, fmt.Errorf("intset: %d elements don't fit blob length %d", length, len(p))
+	}
+	elems := make([][]byte, 0, length)
+	for i := uint32(0); i < length; i++ {
+		elem, err := b.int(int(width))
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// Decodes a zipmap, deprecated since RDB version 4 but still readable.
+// https://github.com/redis/redis/blob/7.2/src/zipmap.c
+//
+//	<zmlen> <len> "key" <len> <free> "value" <free bytes> ... <0xFF>
+func decodeZipmap(p []byte) ([][]byte, error) {
+	b := &blob{b: p, enc: "zipmap"}
+	if _, err := b.readByte(); err != nil { // zmlen; only valid below 254, so unused
+		return nil, err
+	}
+	// Reads a length; returns -1 on the end marker.
+	readLen := func() (int, error) {
+		l, err := b.readByte()
+		switch {
+		case err != nil:
+			return 0, err
+		case l == 0xFF:
+			return -1, nil
+		case l == 0xFE:
+			p, err := b.next(4)
+			if err != nil {
+				return 0, err
+			}
+			return int(binary.LittleEndian.Uint32(p)), nil
+		default:
+			return int(l), nil
+		}
+	}
+	var elems [][]byte
+	for {
+		l, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return elems, nil
+		}
+		key, err := b.next(l)
+		if err != nil {
+			return nil, err
+		}
+		if l, err = readLen(); err != nil {
+			return nil, err
+		}
+		if l < 0 {
+			return nil, fmt.Errorf("zipmap: missing value for key %q", key)
+		}
+		free, err := b.readByte()
+		if err != nil {
+			return nil, err
+		}
+		value, err := b.next(l)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := b.next(int(free)); err != nil {
+			return nil, err
+		}
+		elems = append(elems, key, value)
+	}
+}
+
+// Converts alternating field/value elements to a hash.
+func pairsToHash(elems [][]byte) (RDBHash, error) {
+	if len(elems)%2 != 0 {
+		return nil, fmt.Errorf("odd number of hash elements: %d", len(elems))
+	}
+	hash := make(RDBHash, 0, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		hash = append(hash, RDBHashEntry{elems[i], elems[i+1]})
+	}
+	return hash, nil
+}
+
+// Converts alternating member/score elements to a sorted set.
+func pairsToSortedSet(elems [][]byte) (RDBSortedSet, error) {
+	if len(elems)%2 != 0 {
+		return nil, fmt.Errorf("odd number of sorted set elements: %d", len(elems))
+	}
+	zset := make(RDBSortedSet, 0, len(elems)/2)
+	for i := 0; i < len(elems); i += 2 {
+		score, err := strconv.ParseFloat(string(elems[i+1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set score %q: %w", elems[i+1], err)
+		}
+		zset = append(zset, RDBSortedSetEntry{elems[i], score})
+	}
+	return zset, nil
+}
@@ -0,0 +1,206 @@
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Blobs below are laid out as Redis writes them; see the links on each
+// decoder for the formats.
+
+func TestDecodeListpack(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	tests := []struct {
+		name    string
+		in      []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		{"empty", []byte("\x07\x00\x00\x00\x00\x00\xff"), nil, false},
+		{"hash a=1", []byte("\x0c\x00\x00\x00\x02\x00\x81a\x02\x01\x01\xff"), strs("a", "1"), false},
+		{
+			"integers",
+			[]byte("\x1e\x00\x00\x00\x05\x00" +
+				"\x7f\x01" + // 127, 7 bit uint
+				"\xdf\xff\x02" + // -1, 13 bit int
+				"\xc3\xe8\x02" + // 1000, 13 bit int
+				"\xf2\xa0\x86\x01\x04" + // 100000, int24
+				"\xf4\x00\x00\x00\x00\x01\x00\x00\x00\x09" + // 1<<32, int64
+				"\xff"),
+			strs("127", "-1", "1000", "100000", "4294967296"),
+			false,
+		},
+		{
+			"12 bit string",
+			append(append([]byte("\x50\x00\x00\x00\x01\x00\xe0\x46"), long...), "\x48\xff"...),
+			strs(long),
+			false,
+		},
+		{"length mismatch", []byte("\x0d\x00\x00\x00\x02\x00\x81a\x02\x01\x01\xff"), nil, true},
+		{"truncated string", []byte("\x09\x00\x00\x00\x01\x00\x85ab"), nil, true},
+		{"bad encoding", []byte("\x09\x00\x00\x00\x01\x00\xf5\x01\xff"), nil, true},
+		{"missing end", []byte("\x0b\x00\x00\x00\x02\x00\x81a\x02\x01\x01"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeListpack(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeListpack(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeListpack(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		{"empty", []byte("\x0b\x00\x00\x00\x0a\x00\x00\x00\x00\x00\xff"), nil, false},
+		{
+			"list a 2 300",
+			[]byte("\x14\x00\x00\x00\x0f\x00\x00\x00\x03\x00" +
+				"\x00\x01a" + // "a"
+				"\x03\xf3" + // 2, 4 bit immediate
+				"\x02\xc0\x2c\x01" + // 300, int16
+				"\xff"),
+			strs("a", "2", "300"),
+			false,
+		},
+		{
+			"integers",
+			[]byte("\x25\x00\x00\x00\x22\x00\x00\x00\x05\x00" +
+				"\x00\xfe\x80" + // -128, int8
+				"\x03\xf0\xa0\x86\x01" + // 100000, int24
+				"\x05\xd0\xff\xff\xff\xff" + // -1, int32
+				"\x06\xe0\x00\x00\x00\x00\x01\x00\x00\x00" + // 1<<32, int64
+				"\x0a\xf1" + // 0, 4 bit immediate
+				"\xff"),
+			strs("-128", "100000", "-1", "4294967296", "0"),
+			false,
+		},
+		{
+			"14 bit string",
+			[]byte("\x0f\x00\x00\x00\x0a\x00\x00\x00\x01\x00\x00\x40\x01z\xff"),
+			strs("z"),
+			false,
+		},
+		{"length mismatch", []byte("\x0c\x00\x00\x00\x0a\x00\x00\x00\x00\x00\xff"), nil, true},
+		{"bad encoding", []byte("\x0d\x00\x00\x00\x0a\x00\x00\x00\x01\x00\x00\xc1\xff"), nil, true},
+		{"truncated string", []byte("\x0d\x00\x00\x00\x0a\x00\x00\x00\x01\x00\x00\x05a"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeZiplist(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeZiplist(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeZiplist(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeIntset(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		{"int16", []byte("\x02\x00\x00\x00\x03\x00\x00\x00\x01\x00\x02\x00\x03\x00"), strs("1", "2", "3"), false},
+		{"int32", []byte("\x04\x00\x00\x00\x02\x00\x00\x00\xff\xff\xff\xff\x00\x00\x01\x00"), strs("-1", "65536"), false},
+		{"int64", []byte("\x08\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"), strs("-9223372036854775808"), false},
+		{"bad encoding", []byte("\x03\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00"), nil, true},
+		{"length mismatch", []byte("\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeIntset(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeIntset(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeIntset(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeZipmap(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		want    [][]byte
+		wantErr bool
+	}{
+		// The example from zipmap.c.
+		{"foo=bar hello=world", []byte("\x02\x03foo\x03\x00bar\x05hello\x05\x00world\xff"), strs("foo", "bar", "hello", "world"), false},
+		{"free bytes", []byte("\x01\x01a\x01\x02b\x00\x00\xff"), strs("a", "b"), false},
+		{"5 byte length", []byte("\x01\xfe\x01\x00\x00\x00a\x01\x00b\xff"), strs("a", "b"), false},
+		{"missing value", []byte("\x01\x01a\xff"), nil, true},
+		{"truncated", []byte("\x01\x03foo\x03\x00ba"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeZipmap(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeZipmap(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeZipmap(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadQuicklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		vt      byte
+		in      []byte
+		want    RDBList
+		wantErr bool
+	}{
+		{
+			"ziplist nodes",
+			14,
+			[]byte("\x02" +
+				"\x0e\x0e\x00\x00\x00\x0a\x00\x00\x00\x01\x00\x00\x01a\xff" +
+				"\x0d\x0d\x00\x00\x00\x0a\x00\x00\x00\x01\x00\x00\xf3\xff"),
+			RDBList(strs("a", "2")),
+			false,
+		},
+		{
+			"listpack and plain nodes",
+			18,
+			[]byte("\x02" +
+				"\x02\x0c\x0c\x00\x00\x00\x02\x00\x81a\x02\x01\x01\xff" +
+				"\x01\x03big"),
+			RDBList(strs("a", "1", "big")),
+			false,
+		},
+		{"bad container", 18, []byte("\x01\x03\x01a"), nil, true},
+		{"truncated", 18, []byte("\x02\x01\x01a"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &rdbParser{dbfile: &rdbReader{r: bytes.NewReader(tt.in)}}
+			got, err := r.readValue(tt.vt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readValue(%d, %q) error = %v, wantErr %v", tt.vt, tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readValue(%d, %q) = %q, want %q", tt.vt, tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// strs converts strings to elements, for comparing decoded values.
+func strs(s ...string) [][]byte {
+	elems := make([][]byte, len(s))
+	for i := range s {
+		elems[i] = []byte(s[i])
+	}
+	return elems
+}
+
+func TestReadValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		vt      byte
+		in      string
+		want    interface{}
+		wantErr bool
+	}{
+		{"string", 0, "\x03bar", []byte("bar"), false},
+		{"int string", 0, "\xc0\x7b", []byte("123"), false},
+		{"negative int string", 0, "\xc0\xff", []byte("-1"), false},
+		{"int32 string", 0, "\xc2\x00\x00\x00\x80", []byte("-2147483648"), false},
+		{"32 bit length", 0, "\x80\x00\x00\x00\x02ab", []byte("ab"), false},
+		{"list", 1, "\x02\x01a\x02bc", RDBList(strs("a", "bc")), false},
+		{"set", 2, "\x01\xc1\x39\x30", RDBSet(strs("12345")), false},
+		{
+			"sorted set, string scores",
+			3,
+			"\x03\x01a\x031.5\x01b\xfe\x01c\xff",
+			RDBSortedSet{{[]byte("a"), 1.5}, {[]byte("b"), math.Inf(1)}, {[]byte("c"), math.Inf(-1)}},
+			false,
+		},
+		{"hash", 4, "\x02\x01g\x00\x01f\x01v", RDBHash{{[]byte("g"), []byte{}}, {[]byte("f"), []byte("v")}}, false},
+		{
+			"sorted set, binary scores",
+			5,
+			"\x01\x01a\x00\x00\x00\x00\x00\x00\xf8\x3f",
+			RDBSortedSet{{[]byte("a"), 1.5}},
+			false,
+		},
+		{"truncated list", 1, "\x02\x01a", nil, true},
+		{"bad score", 3, "\x01\x01a\x01x", nil, true},
+		{"unknown type", 8, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &rdbParser{dbfile: &rdbReader{r: bytes.NewReader([]byte(tt.in))}}
+			got, err := r.readValue(tt.vt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readValue(%d, %q) error = %v, wantErr %v", tt.vt, tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readValue(%d, %q) = %#v, want %#v", tt.vt, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// testRDB returns an RDB file, laid out as Redis writes it, with a string key
+// that expires, an intset, and a listpack hash, followed by its checksum.
+func testRDB() []byte {
+	p := []byte("REDIS0011" +
+		"\xfa\x09redis-ver\x057.2.4" +
+		"\xfa\x0aredis-bits\xc0\x40" +
+		"\xfe\x00" + // SELECTDB 0
+		"\xfb\x03\x01" + // RESIZEDB 3 keys, 1 with an expiry
+		"\xfc\x00\xbc\xf1\x5f\x96\x01\x00\x00" + // Expires at 1745366400000 ms
+		"\x00\x03foo\x03bar" +
+		"\x0b\x03set\x0c\x02\x00\x00\x00\x02\x00\x00\x00\x01\x00\x02\x00" +
+		"\x10\x04hash\x0c\x0c\x00\x00\x00\x02\x00\x81a\x02\x01\x01\xff" +
+		"\xff")
+	return binary.LittleEndian.AppendUint64(p, CRC64(0, p))
+}
+
+func TestParseRDB(t *testing.T) {
+	want := []RDBEntry{
+		{Key: []byte("foo"), Value: []byte("bar"), Expiry: time.UnixMilli(1745366400000)},
+		{Key: []byte("set"), Value: RDBSet(strs("1", "2"))},
+		{Key: []byte("hash"), Value: RDBHash{{[]byte("a"), []byte("1")}}},
+	}
+	// Parse a byte at a time, as when the file's streamed from a master.
+	var got []RDBEntry
+	info, err := ParseRDB(iotest.OneByteReader(bytes.NewReader(testRDB())), func(e RDBEntry) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseRDB() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRDB() entries = %#v, want %#v", got, want)
+	}
+	if info.Version != 11 || info.Aux["redis-ver"] != "7.2.4" || info.Aux["redis-bits"] != "64" {
+		t.Errorf("ParseRDB() info = %+v", info)
+	}
+}
+
+func TestParseRDBOpcodes(t *testing.T) {
+	p := []byte("REDIS0011" +
+		"\xf5\x05lib()" + // FUNCTION2
+		"\xfe\x00" +
+		"\xf8\x05" + // IDLE 5 seconds
+		"\x00\x01a\x011" +
+		"\xf9\x07" + // FREQ 7
+		"\x00\x01b\x012" +
+		"\xff")
+	p = binary.LittleEndian.AppendUint64(p, CRC64(0, p))
+	want := []RDBEntry{
+		{Key: []byte("a"), Value: []byte("1"), Idle: 5 * time.Second, HasIdle: true},
+		{Key: []byte("b"), Value: []byte("2"), Freq: 7, HasFreq: true},
+	}
+	var got []RDBEntry
+	info, err := ParseRDB(bytes.NewReader(p), func(e RDBEntry) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseRDB() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRDB() entries = %#v, want %#v", got, want)
+	}
+	if want := [][]byte{[]byte("lib()")}; !reflect.DeepEqual(info.Functions, want) {
+		t.Errorf("ParseRDB() functions = %q, want %q", info.Functions, want)
+	}
+}
+
+func TestParseRDBErrors(t *testing.T) {
+	rdb := testRDB()
+	badChecksum := bytes.Clone(rdb)
+	badChecksum[len(badChecksum)-1] ^= 1
+	noChecksum := append(bytes.Clone(rdb[:len(rdb)-8]), make([]byte, 8)...)
+	errStop := errors.New("stop")
+	tests := []struct {
+		name      string
+		in        []byte
+		fnErr     error
+		wantErr   error
+		wantParse bool // Whether the error's an *RDBParseError
+	}{
+		{"checksums disabled", noChecksum, nil, nil, false},
+		{"bad checksum", badChecksum, nil, ErrRDBChecksum, true},
+		{"truncated", rdb[:len(rdb)-20], nil, io.ErrUnexpectedEOF, true},
+		{"error from fn", rdb, errStop, errStop, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRDB(bytes.NewReader(tt.in), func(RDBEntry) error { return tt.fnErr })
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseRDB() error = %v, want %v", err, tt.wantErr)
+			}
+			var parseErr *RDBParseError
+			if errors.As(err, &parseErr) != tt.wantParse {
+				t.Errorf("ParseRDB() error = %#v, want *RDBParseError %v", err, tt.wantParse)
+			}
+		})
+	}
+}
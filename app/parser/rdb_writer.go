@@ -0,0 +1,261 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// RDB version written by RDBWriter.
+const rdbVersion = 11
+
+// RDBWriter writes a dataset in the RDB file format. Sections must be written
+// in order: the header, any aux fields, then for each database a selector
+// followed by its entries, and finally the EOF marker and checksum.
+// https://rdb.fnordig.de/file_format.html
+type RDBWriter struct {
+	w   io.Writer
+	crc uint64
+	// Compress strings longer than 20 bytes with LZF, where it saves space.
+	Compress bool
+}
+
+func NewRDBWriter(w io.Writer) *RDBWriter {
+	return &RDBWriter{w: w}
+}
+
+// WriteHeader writes the magic string and version.
+func (r *RDBWriter) WriteHeader() error {
+	return r.write([]byte(fmt.Sprintf("REDIS%04d", rdbVersion)))
+}
+
+// WriteAux writes an auxiliary (metadata) field.
+func (r *RDBWriter) WriteAux(key, value string) error {
+	if err := r.write([]byte{auxFlag}); err != nil {
+		return err
+	}
+	if err := r.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return r.writeString([]byte(value))
+}
+
+// WriteSelectDB starts the database section for database `idx`, which holds
+// `size` keys, `expires` of which have an expiry.
+func (r *RDBWriter) WriteSelectDB(idx, size, expires int) error {
+	if err := r.write([]byte{selFlag}); err != nil {
+		return err
+	}
+	if err := r.writeLength(uint64(idx)); err != nil {
+		return err
+	}
+	if err := r.write([]byte{htsFlag}); err != nil {
+		return err
+	}
+	if err := r.writeLength(uint64(size)); err != nil {
+		return err
+	}
+	return r.writeLength(uint64(expires))
+}
+
+// WriteEntry writes a key/value pair, preceded by its expiry if it has one.
+func (r *RDBWriter) WriteEntry(e RDBEntry) error {
+	if !e.Expiry.IsZero() {
+		buf := make([]byte, 9)
+		buf[0] = exmFlag
+		binary.LittleEndian.PutUint64(buf[1:], uint64(e.Expiry.UnixMilli()))
+		if err := r.write(buf); err != nil {
+			return err
+		}
+	}
+	vt, err := valueType(e.Value)
+	if err != nil {
+		return err
+	}
+	if err := r.write([]byte{vt}); err != nil {
+		return err
+	}
+	if err := r.writeString(e.Key); err != nil {
+		return err
+	}
+	return r.writeValue(e.Value)
+}
+
+// WriteEOF writes the EOF marker followed by the checksum of everything
+// written before it.
+func (r *RDBWriter) WriteEOF() error {
+	if err := r.write([]byte{eofFlag}); err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, r.crc)
+	_, err := r.w.Write(buf)
+	return err
+}
+
+func (r *RDBWriter) write(p []byte) error {
+	r.crc = CRC64(r.crc, p)
+	_, err := r.w.Write(p)
+	return err
+}
+
+// Writes a size encoded length.
+// https://rdb.fnordig.de/file_format.html#length-encoding
+func (r *RDBWriter) writeLength(l uint64) error {
+	switch {
+	case l < 1<<6: // Size fits in remaining 6 bits.
+		return r.write([]byte{byte(l)})
+	case l < 1<<14: // Size is in remaining 6 bits plus next byte.
+		return r.write([]byte{byte(l>>8) | 0b01000000, byte(l)})
+	case l <= 0xFFFFFFFF: // Size is in next 4 bytes, big endian.
+		buf := make([]byte, 5)
+		buf[0] = 0b10000000
+		binary.BigEndian.PutUint32(buf[1:], uint32(l))
+		return r.write(buf)
+	default: // Size is in next 8 bytes, big endian.
+		buf := make([]byte, 9)
+		buf[0] = 0b10000001
+		binary.BigEndian.PutUint64(buf[1:], l)
+		return r.write(buf)
+	}
+}
+
+// Writes a string, using an integer encoding where it round-trips.
+// https://rdb.fnordig.de/file_format.html#string-encoding
+func (r *RDBWriter) writeString(s []byte) error {
+	if len(s) <= 11 {
+		if i, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(i, 10) == string(s) {
+			return r.writeIntString(i)
+		}
+	}
+	if r.Compress && len(s) > 20 {
+		if c := lzfCompress(s); c != nil {
+			return r.writeLZFString(c, len(s))
+		}
+	}
+	if err := r.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return r.write(s)
+}
+
+// Writes LZF compressed data `c`, which expands to `ulen` bytes.
+func (r *RDBWriter) writeLZFString(c []byte, ulen int) error {
+	if err := r.write([]byte{lzfFlag}); err != nil {
+		return err
+	}
+	if err := r.writeLength(uint64(len(c))); err != nil {
+		return err
+	}
+	if err := r.writeLength(uint64(ulen)); err != nil {
+		return err
+	}
+	return r.write(c)
+}
+
+// Writes an integer as a string, in the smallest integer encoding it fits.
+func (r *RDBWriter) writeIntString(i int64) error {
+	switch {
+	case i >= -1<<7 && i < 1<<7:
+		return r.write([]byte{0b11000000, byte(int8(i))})
+	case i >= -1<<15 && i < 1<<15:
+		buf := []byte{0b11000001, 0, 0}
+		binary.LittleEndian.PutUint16(buf[1:], uint16(int16(i)))
+		return r.write(buf)
+	default:
+		buf := []byte{0b11000010, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(buf[1:], uint32(int32(i)))
+		return r.write(buf)
+	}
+}
+
+// Returns the value type flag for `v`.
+func valueType(v interface{}) (byte, error) {
+	switch v.(type) {
+	case []byte:
+		return 0x0, nil // String Encoding
+	case RDBList:
+		return 0x1, nil // List Encoding
+	case RDBSet:
+		return 0x2, nil // Set Encoding
+	case RDBHash:
+		return 0x4, nil // Hash Encoding
+	case RDBSortedSet:
+		return 0x5, nil // Sorted Set Encoding, with binary doubles
+	default:
+		return 0, fmt.Errorf("unsupported value type: %T", v)
+	}
+}
+
+// SerializedLength returns the number of bytes `v` takes in an RDB file,
+// not counting its value type.
+func SerializedLength(v interface{}, compress bool) (int, error) {
+	cw := &countingWriter{}
+	err := (&RDBWriter{w: cw, Compress: compress}).writeValue(v)
+	return cw.n, err
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+func (r *RDBWriter) writeValue(v interface{}) error {
+	switch v := v.(type) {
+	case []byte:
+		return r.writeString(v)
+	case RDBList:
+		return r.writeStrings(v)
+	case RDBSet:
+		return r.writeStrings(v)
+	case RDBHash:
+		if err := r.writeLength(uint64(len(v))); err != nil {
+			return err
+		}
+		for _, e := range v {
+			if err := r.writeString(e.Field); err != nil {
+				return err
+			}
+			if err := r.writeString(e.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	case RDBSortedSet:
+		if err := r.writeLength(uint64(len(v))); err != nil {
+			return err
+		}
+		buf := make([]byte, 8)
+		for _, e := range v {
+			if err := r.writeString(e.Member); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(e.Score))
+			if err := r.write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value type: %T", v)
+	}
+}
+
+// Writes a size encoded number of strings.
+func (r *RDBWriter) writeStrings(elems [][]byte) error {
+	if err := r.writeLength(uint64(len(elems))); err != nil {
+		return err
+	}
+	for _, e := range elems {
+		if err := r.writeString(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
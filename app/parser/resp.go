@@ -2,69 +2,177 @@ package parser
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"strconv"
 )
 
-// RESPParser parses incoming data on `scanner` as RESP data.
+// RESPParser parses incoming data on `reader` as RESP data.
 type RESPParser = Parser
 
 type respParser struct {
-	scanner *bufio.Scanner
+	reader *bufio.Reader
+	// Set when parsing from a buffer that may only hold part of the value,
+	// so that bulk strings aren't allocated until they've all arrived.
+	src *bytes.Reader
+	// Set to collect the bytes of the value as they're read, in raw.
+	keepRaw bool
+	raw     []byte
 }
 
-// RespParser parses incoming data on `scanner` as RESP data.
-func NewRESPParser(scanner *bufio.Scanner) RESPParser {
-	return &respParser{scanner}
+// RespParser parses incoming data on `reader` as RESP data.
+//
+// The reader may be shared with other consumers of the same stream (e.g. to
+// read an RDB payload following a command response); the parser never reads
+// past the end of the value it's parsing.
+func NewRESPParser(reader *bufio.Reader) RESPParser {
+	return &respParser{reader: reader}
+}
+
+// ParseRESPRaw parses a single RESP value from `reader`, like
+// RESPParser.Parse, and also returns the value's bytes exactly as they were
+// read, so that it can be passed on verbatim. Returns nil values on error.
+func ParseRESPRaw(reader *bufio.Reader) (ParseResponse, []byte) {
+	r := &respParser{reader: reader, keepRaw: true}
+	data := r.Parse()
+	if data == nil {
+		return nil, nil
+	}
+	return data, r.raw
 }
 
 func (r *respParser) Parse() ParseResponse {
-	if !r.scanner.Scan() {
-		if r.scanner.Err() != nil {
-			log.Println("[RESPParser] Scan() encountered error: ", r.scanner.Err())
+	data, err := r.parse()
+	if err != nil {
+		// A deadline is the caller's way of interrupting the read (e.g. to
+		// close the connection at shutdown), not a malformed request.
+		if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+			log.Println("[RESPParser] Error while parsing: ", err)
 		}
 		return nil
 	}
+	return data
+}
 
+func (r *respParser) parse() (ParseResponse, error) {
 	// Read the first byte to determine how to process the data
 	// https://redis.io/docs/latest/develop/reference/protocol-spec/#resp-protocol-description
 	///// At this point in the exercise, we only need to handle Array and String
-	data := r.scanner.Bytes()
+	data, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
 	if len(data) == 0 {
-		log.Println("[RESPParser] no bytes received from Scan()")
-		return nil
+		return nil, fmt.Errorf("no bytes received")
 	}
 	switch fb := data[0]; fb {
 	case byte('*'):
 		// Array, data[1:] contains the length
 		arrLen, err := strconv.Atoi(string(data[1:]))
 		if err != nil {
-			log.Println("[RESPParser] Error while processing array length: ", err.Error())
-			return nil
+			return nil, fmt.Errorf("error while processing array length: %w", err)
 		}
 		arr := []interface{}{}
 		// Read `arrLen` number of elements into `arr`
 		for arrLen > 0 {
-			arr = append(arr, r.Parse())
+			el, err := r.parse()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, el)
 			arrLen--
 		}
-		return arr
+		return arr, nil
 
 	case byte('+'):
 		// Simple string, data[1:] contains string
-		return data[1:]
+		return data[1:], nil
+
+	case byte('-'):
+		// Simple error, data[1:] contains the error code and message
+		return RESPError(data[1:]), nil
 
 	case byte('$'):
 		// Bulk string, data[1:] contains length
-		// Length is unnecessary, as `r.scanner` is just going to read untiil
-		// the next CLRF.
-		if !r.scanner.Scan() {
-			log.Println("[RESPParser] Expected to Scan() a string byte; received error: ", r.scanner.Err())
-			return nil
+		strLen, err := strconv.Atoi(string(data[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("error while processing bulk string length: %w", err)
+		}
+		if strLen < 0 {
+			// Null bulk string
+			return nil, nil
+		}
+		if strLen > maxStringLen {
+			return nil, fmt.Errorf("bulk string length %d exceeds maximum %d", strLen, maxStringLen)
+		}
+		if r.src != nil && r.src.Len()+r.reader.Buffered() < strLen+2 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		// String data is followed by a CRLF.
+		str := make([]byte, strLen+2)
+		if _, err := io.ReadFull(r.reader, str); err != nil {
+			return nil, err
+		}
+		if str[strLen] != '\r' || str[strLen+1] != '\n' {
+			return nil, fmt.Errorf("bulk string of length %d isn't followed by CRLF", strLen)
+		}
+		if r.keepRaw {
+			r.raw = append(r.raw, str...)
 		}
-		return r.scanner.Text()
+		return string(str[:strLen]), nil
 	default:
-		log.Printf("[RESPParser] Unexpected first byte: %#v\n", data)
-		return nil
+		return nil, fmt.Errorf("unexpected first byte: %#v", data)
+	}
+}
+
+// readLine reads up to the next CRLF, returning the line without it.
+func (r *respParser) readLine() ([]byte, error) {
+	line, err := r.reader.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.keepRaw {
+		r.raw = append(r.raw, line...)
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+// RESPError is an error reply, e.g. "ERR unknown command".
+type RESPError string
+
+func (e RESPError) Error() string {
+	return string(e)
+}
+
+// ErrIncomplete is returned by ParseRESPBuffer when the buffer does not (yet)
+// hold a complete RESP value.
+var ErrIncomplete = errors.New("incomplete RESP value")
+
+// ParseRESPBuffer parses a single RESP value from the start of `buf`, for
+// callers that manage their own read buffers rather than a blocking reader.
+// It returns the value along with the number of bytes consumed; if `buf` ends
+// before the value does, ErrIncomplete is returned and nothing is consumed.
+//
+// Values are returned with the same types as RESPParser.Parse. Callers may
+// retry as more input arrives; a bulk string is only read (and allocated) once
+// `buf` holds all of it, so retrying costs little more than its header.
+func ParseRESPBuffer(buf []byte) (ParseResponse, int, error) {
+	src := bytes.NewReader(buf)
+	// Most values are small; avoid allocating a full-sized buffer per call.
+	reader := bufio.NewReaderSize(src, 64)
+	data, err := (&respParser{reader: reader, src: src}).parse()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, 0, ErrIncomplete
+	}
+	if err != nil {
+		return nil, 0, err
 	}
+	return data, len(buf) - src.Len() - reader.Buffered(), nil
 }
@@ -0,0 +1,80 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRESPParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    ParseResponse
+		wantErr bool
+	}{
+		{"array", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []interface{}{"GET", "k"}, false},
+		{"empty bulk string", "$0\r\n\r\n", "", false},
+		{"binary bulk string", "$4\r\na\r\nb\r\n", "a\r\nb", false},
+		{"null bulk string", "*1\r\n$-1\r\n", []interface{}{nil}, false},
+		{"simple string", "+OK\r\n", []byte("OK"), false},
+		{"error", "-ERR nope\r\n", RESPError("ERR nope"), false},
+		{"bulk string over the limit", "$9999999999\r\n", nil, true},
+		{"bulk string without CRLF", "$3\r\nabcde\r\n", nil, true},
+		{"truncated bulk string", "$5\r\nab", nil, true},
+		{"bad length", "$x\r\n", nil, true},
+		{"bad first byte", "?\r\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &respParser{reader: bufio.NewReader(strings.NewReader(tt.in))}
+			got, err := p.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRESPBuffer(t *testing.T) {
+	full := "*2\r\n$3\r\nGET\r\n$10\r\n0123456789\r\n+OK\r\n"
+	// Every prefix of the first value is incomplete.
+	for i := 0; i < len(full)-len("+OK\r\n"); i++ {
+		if _, n, err := ParseRESPBuffer([]byte(full[:i])); !errors.Is(err, ErrIncomplete) || n != 0 {
+			t.Errorf("ParseRESPBuffer(%q) = %d, %v, want 0, ErrIncomplete", full[:i], n, err)
+		}
+	}
+	got, n, err := ParseRESPBuffer([]byte(full))
+	if err != nil {
+		t.Fatalf("ParseRESPBuffer(%q) error = %v", full, err)
+	}
+	if want := []interface{}{"GET", "0123456789"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRESPBuffer(%q) = %#v, want %#v", full, got, want)
+	}
+	if want := len(full) - len("+OK\r\n"); n != want {
+		t.Errorf("ParseRESPBuffer(%q) consumed %d bytes, want %d", full, n, want)
+	}
+}
+
+func TestParseRESPRaw(t *testing.T) {
+	// Values as a master might send them, not as we'd encode them.
+	values := []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\na\r\n$-1\r\n",
+		"*2\n$3\r\nGET\r\n$02\r\nbb\r\n",
+		"*1\r\n+PING\r\n",
+	}
+	reader := bufio.NewReader(strings.NewReader(strings.Join(values, "")))
+	for _, want := range values {
+		if _, raw := ParseRESPRaw(reader); string(raw) != want {
+			t.Errorf("ParseRESPRaw() raw = %q, want %q", raw, want)
+		}
+	}
+	if got, raw := ParseRESPRaw(reader); got != nil || raw != nil {
+		t.Errorf("ParseRESPRaw() at EOF = %#v, %q, want nil", got, raw)
+	}
+}
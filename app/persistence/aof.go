@@ -0,0 +1,445 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// The append only file is split into parts, as in Redis 7: a base file
+// holding a snapshot of the dataset in RDB format, and incremental files
+// holding every write command since, in RESP. A manifest lists the parts in
+// order, and is only ever replaced by an atomic rename.
+//
+// <appenddirname>/
+//   <appendfilename>.<seq>.base.rdb
+//   <appendfilename>.<seq>.incr.aof
+//   <appendfilename>.manifest
+
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// Type of each file in the manifest.
+const (
+	aofBase = "b"
+	aofIncr = "i"
+)
+
+type aofFile struct {
+	name string
+	seq  int
+	typ  string
+}
+
+type aofManifest struct {
+	files []aofFile
+}
+
+func (m *aofManifest) base() (aofFile, bool) {
+	for _, f := range m.files {
+		if f.typ == aofBase {
+			return f, true
+		}
+	}
+	return aofFile{}, false
+}
+
+func (m *aofManifest) incrs() []aofFile {
+	var incrs []aofFile
+	for _, f := range m.files {
+		if f.typ == aofIncr {
+			incrs = append(incrs, f)
+		}
+	}
+	return incrs
+}
+
+// Highest sequence number used for files of type `typ`.
+func (m *aofManifest) lastSeq(typ string) int {
+	seq := 0
+	for _, f := range m.files {
+		if f.typ == typ && f.seq > seq {
+			seq = f.seq
+		}
+	}
+	return seq
+}
+
+var aof struct {
+	mu       sync.Mutex
+	manifest *aofManifest
+	// Incremental file that write commands are appended to; nil until
+	// OpenAOF is called.
+	incr *os.File
+	// Whether there are writes that haven't been fsynced yet.
+	unsynced bool
+
+	rewriting     bool
+	lastRewriteOK bool
+}
+
+func init() {
+	aof.lastRewriteOK = true
+}
+
+// AOFStatus describes the state of AOF persistence, for INFO.
+type AOFStatus struct {
+	Enabled           bool
+	RewriteInProgress bool
+	LastBGRewriteOK   bool
+}
+
+func GetAOFStatus() AOFStatus {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return AOFStatus{
+		Enabled:           aof.incr != nil,
+		RewriteInProgress: aof.rewriting,
+		LastBGRewriteOK:   aof.lastRewriteOK,
+	}
+}
+
+func aofDir() string {
+	dir, _ := config.Get("dir")
+	appenddirname, _ := config.Get("appenddirname")
+	return filepath.Join(dir, appenddirname)
+}
+
+func aofFilename() string {
+	appendfilename, _ := config.Get("appendfilename")
+	return appendfilename
+}
+
+func manifestPath() string {
+	return filepath.Join(aofDir(), aofFilename()+".manifest")
+}
+
+// LoadAOF loads the dataset from the append only file, replaying each logged
+// write command through `exec`. Returns false if there's no AOF to load.
+func LoadAOF(exec func(command []interface{})) (bool, error) {
+	m, err := readManifest()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if base, ok := m.base(); ok {
+		log.Printf("[AOF] Loading base file %s\n", base.name)
+		if err := loadAOFBase(base.name); err != nil {
+			return false, fmt.Errorf("loading %s: %w", base.name, err)
+		}
+	}
+	incrs := m.incrs()
+	for i, incr := range incrs {
+		log.Printf("[AOF] Loading incremental file %s\n", incr.name)
+		last := i == len(incrs)-1
+		if err := replayAOF(incr.name, last, exec); err != nil {
+			return false, fmt.Errorf("loading %s: %w", incr.name, err)
+		}
+	}
+	// Loading the AOF isn't a change to the dataset.
+	c := cache.GetDefaultCache()
+	c.MarkSaved(c.Dirty())
+	return true, nil
+}
+
+func loadAOFBase(name string) error {
+	f, err := os.Open(filepath.Join(aofDir(), name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := cache.GetDefaultCache().LoadRDB(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// replayAOF executes every command in the incremental file `name`. If `last`
+// is set, a truncated command at the end of the file (say, from a crash
+// mid-write) is dropped and the file truncated, rather than failing the load.
+func replayAOF(name string, last bool, exec func(command []interface{})) error {
+	path := filepath.Join(aofDir(), name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	pos := 0
+	for pos < len(data) {
+		parsed, n, err := parser.ParseRESPBuffer(data[pos:])
+		if errors.Is(err, parser.ErrIncomplete) && last {
+			log.Printf("[AOF] %s ends with a truncated command; truncating from %d to %d bytes\n", name, len(data), pos)
+			return os.Truncate(path, int64(pos))
+		}
+		if err != nil {
+			return fmt.Errorf("bad command at offset %d: %w", pos, err)
+		}
+		command, ok := parsed.([]interface{})
+		if !ok || len(command) == 0 {
+			return fmt.Errorf("bad command at offset %d: %#v", pos, parsed)
+		}
+		exec(command)
+		pos += n
+	}
+	return nil
+}
+
+// OpenAOF starts logging write commands. If there's no AOF yet, one is
+// created with the current dataset as its base.
+func OpenAOF() error {
+	if err := os.MkdirAll(aofDir(), 0o755); err != nil {
+		return err
+	}
+	m, err := readManifest()
+	if os.IsNotExist(err) {
+		// Start from the current dataset.
+		m = &aofManifest{}
+		entries, _, repl := snapshot()
+		base := aofFile{fmt.Sprintf("%s.1.base.rdb", aofFilename()), 1, aofBase}
+		if err := writeAOFBase(base.name, entries, repl); err != nil {
+			return err
+		}
+		m.files = append(m.files, base)
+	} else if err != nil {
+		return err
+	}
+
+	// Carry on appending to the last incremental file, if there is one.
+	var incr *os.File
+	if incrs := m.incrs(); len(incrs) > 0 {
+		last := incrs[len(incrs)-1]
+		incr, err = os.OpenFile(filepath.Join(aofDir(), last.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	} else {
+		incr, m, err = addIncr(m)
+	}
+	if err != nil {
+		return err
+	}
+
+	aof.mu.Lock()
+	aof.manifest = m
+	aof.incr = incr
+	aof.mu.Unlock()
+
+	if fsync, _ := config.Get("appendfsync"); fsync == "everysec" {
+		go syncEverySecond()
+	}
+	return nil
+}
+
+// addIncr creates a new incremental file, and persists a manifest that
+// includes it.
+func addIncr(m *aofManifest) (*os.File, *aofManifest, error) {
+	seq := m.lastSeq(aofIncr) + 1
+	name := fmt.Sprintf("%s.%d.incr.aof", aofFilename(), seq)
+	incr, err := os.OpenFile(filepath.Join(aofDir(), name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	next := &aofManifest{files: append(append([]aofFile{}, m.files...), aofFile{name, seq, aofIncr})}
+	if err := writeManifest(next); err != nil {
+		incr.Close()
+		return nil, nil, err
+	}
+	return incr, next, nil
+}
+
+// AppendCommand logs a write command to the AOF, if it's enabled.
+func AppendCommand(command []string) {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(command)))
+	for _, arg := range command {
+		buf = append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)...)
+	}
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.incr == nil {
+		return
+	}
+	if _, err := aof.incr.Write(buf); err != nil {
+		log.Println("[AOF] Error writing to AOF: ", err)
+		return
+	}
+	if fsync, _ := config.Get("appendfsync"); fsync == "always" {
+		if err := aof.incr.Sync(); err != nil {
+			log.Println("[AOF] Error fsyncing AOF: ", err)
+		}
+		return
+	}
+	aof.unsynced = true
+}
+
+func syncEverySecond() {
+	for range time.Tick(time.Second) {
+		aof.mu.Lock()
+		if aof.incr != nil && aof.unsynced {
+			if err := aof.incr.Sync(); err != nil {
+				log.Println("[AOF] Error fsyncing AOF: ", err)
+			} else {
+				aof.unsynced = false
+			}
+		}
+		aof.mu.Unlock()
+	}
+}
+
+// FlushAOF fsyncs any pending writes to the AOF.
+func FlushAOF() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.incr == nil || !aof.unsynced {
+		return nil
+	}
+	aof.unsynced = false
+	return aof.incr.Sync()
+}
+
+// BGRewriteAOF compacts the AOF: the current dataset is written out as a new
+// base file in the background, and once it's complete, replaces every file
+// before it.
+//
+// The caller must make sure no write commands run while BGRewriteAOF is
+// starting, so that the snapshot lines up with the new incremental file.
+func BGRewriteAOF() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.incr == nil {
+		return errors.New("Append only file is not enabled")
+	}
+	if aof.rewriting {
+		return ErrRewriteInProgress
+	}
+
+	// Everything up to this point will be in the snapshot; everything after
+	// goes in a new incremental file.
+	entries, _, repl := snapshot()
+	incr, m, err := addIncr(aof.manifest)
+	if err != nil {
+		return err
+	}
+	if err := aof.incr.Sync(); err != nil {
+		log.Println("[AOF] Error fsyncing AOF: ", err)
+	}
+	aof.incr.Close()
+	aof.incr = incr
+	aof.manifest = m
+	aof.unsynced = false
+	aof.rewriting = true
+
+	seq := m.lastSeq(aofBase) + 1
+	base := aofFile{fmt.Sprintf("%s.%d.base.rdb", aofFilename(), seq), seq, aofBase}
+	newIncr := m.files[len(m.files)-1]
+	go func() {
+		err := writeAOFBase(base.name, entries, repl)
+		if err == nil {
+			err = finishRewrite(base, newIncr)
+		}
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		aof.rewriting = false
+		aof.lastRewriteOK = err == nil
+		if err != nil {
+			log.Println("[AOF] Background AOF rewrite failed: ", err)
+			return
+		}
+		log.Println("[AOF] Background AOF rewrite finished successfully")
+	}()
+	return nil
+}
+
+// finishRewrite swaps in the manifest for the rewritten AOF, and removes the
+// files it replaces.
+func finishRewrite(base, incr aofFile) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	old := aof.manifest
+	// Keep incremental files created since the rewrite started.
+	next := &aofManifest{files: []aofFile{base}}
+	for _, f := range old.files {
+		if f.typ == aofIncr && f.seq >= incr.seq {
+			next.files = append(next.files, f)
+		}
+	}
+	if err := writeManifest(next); err != nil {
+		os.Remove(filepath.Join(aofDir(), base.name))
+		return err
+	}
+	aof.manifest = next
+	for _, f := range old.files {
+		if f.typ == aofBase || f.seq < incr.seq {
+			if err := os.Remove(filepath.Join(aofDir(), f.name)); err != nil {
+				log.Printf("[AOF] Error removing %s: %s\n", f.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeAOFBase writes `entries` in RDB format to the base file `name`.
+func writeAOFBase(name string, entries []cache.Entry, repl ReplInfo) error {
+	return writeFileAtomic(filepath.Join(aofDir(), name), func(w *bufio.Writer) error {
+		return WriteSnapshot(w, entries, repl)
+	})
+}
+
+func readManifest() (*aofManifest, error) {
+	data, err := os.ReadFile(manifestPath())
+	if err != nil {
+		return nil, err
+	}
+	m := &aofManifest{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Each line is a list of key/value pairs, e.g.
+		// "file appendonly.aof.1.base.rdb seq 1 type b"
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %q", i+1, line)
+		}
+		var f aofFile
+		for j := 0; j < len(fields); j += 2 {
+			switch fields[j] {
+			case "file":
+				f.name = fields[j+1]
+			case "seq":
+				f.seq, err = strconv.Atoi(fields[j+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid AOF manifest line %d: %q", i+1, line)
+				}
+			case "type":
+				f.typ = fields[j+1]
+			}
+		}
+		if f.name == "" || (f.typ != aofBase && f.typ != aofIncr) {
+			// History files are only listed while they're being removed.
+			continue
+		}
+		m.files = append(m.files, f)
+	}
+	return m, nil
+}
+
+func writeManifest(m *aofManifest) error {
+	return writeFileAtomic(manifestPath(), func(w *bufio.Writer) error {
+		for _, f := range m.files {
+			if _, err := fmt.Fprintf(w, "file %s seq %d type %s\n", f.name, f.seq, f.typ); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,180 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+// useTempAOFDir points the AOF at a fresh directory for the test.
+func useTempAOFDir(t *testing.T) string {
+	t.Helper()
+	config.Set("dir", t.TempDir())
+	config.Set("appenddirname", "appendonlydir")
+	config.Set("appendfilename", "appendonly.aof")
+	if err := os.MkdirAll(aofDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return aofDir()
+}
+
+func TestReadManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []aofFile
+		wantErr bool
+	}{
+		{
+			"base and incrs",
+			"file appendonly.aof.1.base.rdb seq 1 type b\n" +
+				"file appendonly.aof.1.incr.aof seq 1 type i\n" +
+				"file appendonly.aof.2.incr.aof seq 2 type i\n",
+			[]aofFile{
+				{"appendonly.aof.1.base.rdb", 1, aofBase},
+				{"appendonly.aof.1.incr.aof", 1, aofIncr},
+				{"appendonly.aof.2.incr.aof", 2, aofIncr},
+			},
+			false,
+		},
+		{
+			"comments, blank lines and fields in any order",
+			"# written by redis\n\n  type b seq 3 file appendonly.aof.3.base.rdb  \n",
+			[]aofFile{{"appendonly.aof.3.base.rdb", 3, aofBase}},
+			false,
+		},
+		{
+			"history files are skipped",
+			"file appendonly.aof.1.base.rdb seq 1 type h\n" +
+				"file appendonly.aof.2.base.rdb seq 2 type b\n",
+			[]aofFile{{"appendonly.aof.2.base.rdb", 2, aofBase}},
+			false,
+		},
+		{"odd number of fields", "file appendonly.aof.1.base.rdb seq\n", nil, true},
+		{"bad seq", "file appendonly.aof.1.base.rdb seq one type b\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempAOFDir(t)
+			if err := os.WriteFile(manifestPath(), []byte(tt.in), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := readManifest()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(m.files, tt.want) {
+				t.Errorf("readManifest() = %v, want %v", m.files, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	useTempAOFDir(t)
+	if _, err := readManifest(); !os.IsNotExist(err) {
+		t.Errorf("readManifest() error = %v, want not exist", err)
+	}
+}
+
+func TestReplayAOF(t *testing.T) {
+	const (
+		set  = "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"
+		incr = "*2\r\n$4\r\nINCR\r\n$1\r\na\r\n"
+	)
+	tests := []struct {
+		name     string
+		in       string
+		last     bool
+		want     [][]interface{}
+		wantSize int
+		wantErr  bool
+	}{
+		{"complete", set + incr, true, [][]interface{}{{"SET", "a", "1"}, {"INCR", "a"}}, len(set + incr), false},
+		{"cut off last file", set + incr[:10], true, [][]interface{}{{"SET", "a", "1"}}, len(set), false},
+		{"cut off earlier file", set + incr[:10], false, [][]interface{}{{"SET", "a", "1"}}, len(set + incr[:10]), true},
+		{"not a command", set + "+OK\r\n", true, [][]interface{}{{"SET", "a", "1"}}, len(set + "+OK\r\n"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useTempAOFDir(t)
+			path := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+			if err := os.WriteFile(path, []byte(tt.in), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var got [][]interface{}
+			err := replayAOF("appendonly.aof.1.incr.aof", tt.last, func(command []interface{}) {
+				got = append(got, command)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replayAOF() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayAOF() ran %q, want %q", got, tt.want)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(info.Size()) != tt.wantSize {
+				t.Errorf("file is %d bytes after replayAOF(), want %d", info.Size(), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestFinishRewrite(t *testing.T) {
+	dir := useTempAOFDir(t)
+	old := []aofFile{
+		{"appendonly.aof.1.base.rdb", 1, aofBase},
+		{"appendonly.aof.1.incr.aof", 1, aofIncr},
+		{"appendonly.aof.2.incr.aof", 2, aofIncr},
+		// Started the rewrite.
+		{"appendonly.aof.3.incr.aof", 3, aofIncr},
+		// Created while the rewrite was in progress.
+		{"appendonly.aof.4.incr.aof", 4, aofIncr},
+	}
+	base := aofFile{"appendonly.aof.2.base.rdb", 2, aofBase}
+	for _, f := range append(old, base) {
+		if err := os.WriteFile(filepath.Join(dir, f.name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aof.mu.Lock()
+	aof.manifest = &aofManifest{files: old}
+	aof.mu.Unlock()
+	t.Cleanup(func() {
+		aof.mu.Lock()
+		aof.manifest = nil
+		aof.mu.Unlock()
+	})
+
+	if err := finishRewrite(base, old[3]); err != nil {
+		t.Fatalf("finishRewrite() error = %v", err)
+	}
+
+	want := []aofFile{base, old[3], old[4]}
+	if !reflect.DeepEqual(aof.manifest.files, want) {
+		t.Errorf("manifest = %v, want %v", aof.manifest.files, want)
+	}
+	m, err := readManifest()
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	if !reflect.DeepEqual(m.files, want) {
+		t.Errorf("manifest on disk = %v, want %v", m.files, want)
+	}
+	for _, f := range old[:3] {
+		if _, err := os.Stat(filepath.Join(dir, f.name)); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed (err = %v)", f.name, err)
+		}
+	}
+	for _, f := range want {
+		if _, err := os.Stat(filepath.Join(dir, f.name)); err != nil {
+			t.Errorf("%s was removed: %v", f.name, err)
+		}
+	}
+}
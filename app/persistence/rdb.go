@@ -0,0 +1,310 @@
+// Package persistence saves the dataset to disk.
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+var ErrSaveInProgress = errors.New("Background save already in progress")
+
+// How long to wait before retrying a failed background save from a save point.
+const bgsaveRetryDelay = 5 * time.Second
+
+var (
+	mu sync.Mutex
+	// Closed when the current save finishes; nil if there isn't one.
+	saving chan struct{}
+	// Whether the current save is a background save.
+	background bool
+	// Time of the last successful save.
+	lastSave = time.Now()
+	// Time and outcome of the last background save.
+	lastBGSaveTry time.Time
+	lastBGSaveOK  = true
+)
+
+// Status describes the state of RDB persistence, for INFO.
+type Status struct {
+	BGSaveInProgress bool
+	LastSave         time.Time
+	LastBGSaveOK     bool
+}
+
+func GetStatus() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return Status{
+		BGSaveInProgress: saving != nil && background,
+		LastSave:         lastSave,
+		LastBGSaveOK:     lastBGSaveOK,
+	}
+}
+
+// Save writes a snapshot of the dataset to the RDB file, and returns once
+// it's on disk.
+func Save() error {
+	if err := startSave(false); err != nil {
+		return err
+	}
+	entries, dirty, repl := snapshot()
+	err := writeRDB(entries, repl)
+	finishSave(dirty, err)
+	return err
+}
+
+// BGSave takes a snapshot of the dataset and writes it to the RDB file in the
+// background.
+func BGSave() error {
+	if err := startSave(true); err != nil {
+		return err
+	}
+	// The snapshot is a copy, so the dataset is free to change while it's
+	// being written.
+	entries, dirty, repl := snapshot()
+	go func() {
+		finishSave(dirty, writeRDB(entries, repl))
+	}()
+	return nil
+}
+
+// ReplInfo is the replication ID and offset that a snapshot of the dataset
+// was taken at. It's saved with the snapshot, so that a replica restarted from
+// it can partially resync from there.
+type ReplInfo struct {
+	ID     string
+	Offset int64
+}
+
+// snapshot copies the dataset, along with the replication ID and offset it's
+// as of; see Cache.Snapshot for `dirty`.
+func snapshot() (entries []cache.Entry, dirty int, repl ReplInfo) {
+	entries, dirty = cache.GetDefaultCache().Snapshot()
+	repl.ID, _ = config.Get("master_replid")
+	offset, _ := config.Get("master_repl_offset")
+	repl.Offset, _ = strconv.ParseInt(offset, 10, 64)
+	return entries, dirty, repl
+}
+
+// WaitForSave blocks until any save in progress has finished.
+func WaitForSave() {
+	mu.Lock()
+	ch := saving
+	mu.Unlock()
+	if ch != nil {
+		<-ch
+	}
+}
+
+func startSave(bg bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if saving != nil {
+		return ErrSaveInProgress
+	}
+	saving = make(chan struct{})
+	background = bg
+	if bg {
+		lastBGSaveTry = time.Now()
+	}
+	return nil
+}
+
+func finishSave(dirty int, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		log.Println("[Persistence] Error saving DB on disk: ", err)
+	} else {
+		log.Println("[Persistence] DB saved on disk")
+		cache.GetDefaultCache().MarkSaved(dirty)
+		lastSave = time.Now()
+	}
+	if background {
+		lastBGSaveOK = err == nil
+	}
+	close(saving)
+	saving = nil
+}
+
+// writeRDB writes `entries` to the RDB file.
+func writeRDB(entries []cache.Entry, repl ReplInfo) error {
+	dir, _ := config.Get("dir")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(rdbPath(), func(w *bufio.Writer) error {
+		return WriteSnapshot(w, entries, repl)
+	})
+}
+
+func rdbPath() string {
+	dir, _ := config.Get("dir")
+	dbfilename, _ := config.Get("dbfilename")
+	return filepath.Join(dir, dbfilename)
+}
+
+// Reload replaces the dataset with the contents of the RDB file.
+func Reload() error {
+	f, err := os.Open(rdbPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = cache.GetDefaultCache().LoadRDB(bufio.NewReader(f))
+	return err
+}
+
+// writeFileAtomic writes to a temporary file with `write`, and renames it
+// over `path` once it's complete and fsynced, so a failed write never
+// clobbers the last good file.
+func writeFileAtomic(path string, write func(w *bufio.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*")
+	if err != nil {
+		return err
+	}
+	// Clean up after ourselves if we don't make it to the rename.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0o644); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteSnapshot encodes `entries` as an RDB file on `w`, taken at the
+// replication ID and offset in `repl`.
+func WriteSnapshot(w io.Writer, entries []cache.Entry, repl ReplInfo) error {
+	rdb := parser.NewRDBWriter(w)
+	compression, _ := config.Get("rdbcompression")
+	rdb.Compress = compression == "yes"
+	if err := rdb.WriteHeader(); err != nil {
+		return err
+	}
+	for _, aux := range auxFields(repl) {
+		if err := rdb.WriteAux(aux[0], aux[1]); err != nil {
+			return err
+		}
+	}
+	expires := 0
+	for _, e := range entries {
+		if !e.Expiry.IsZero() {
+			expires++
+		}
+	}
+	if err := rdb.WriteSelectDB(0, len(entries), expires); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := rdb.WriteEntry(e.RDBEntry()); err != nil {
+			return err
+		}
+	}
+	return rdb.WriteEOF()
+}
+
+// Metadata written at the top of each RDB file.
+func auxFields(repl ReplInfo) [][2]string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return [][2]string{
+		{"redis-ver", "7.2.0"},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(time.Now().Unix(), 10)},
+		{"used-mem", strconv.FormatUint(mem.HeapAlloc, 10)},
+		{"repl-id", repl.ID},
+		{"repl-offset", strconv.FormatInt(repl.Offset, 10)},
+		{"aof-base", "0"},
+	}
+}
+
+// SavePoint triggers a background save once at least Changes changes have
+// been made, and at least Seconds seconds have passed since the last save.
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+// SavePoints parses the "save" config, formatted like
+// "<seconds> <changes> [<seconds> <changes> ...]".
+func SavePoints() ([]SavePoint, error) {
+	save, _ := config.Get("save")
+	fields := strings.Fields(save)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save config %q", save)
+	}
+	var points []SavePoint
+	for len(fields) > 0 {
+		seconds, err := strconv.Atoi(fields[0])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save seconds %q", fields[0])
+		}
+		changes, err := strconv.Atoi(fields[1])
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save changes %q", fields[1])
+		}
+		points = append(points, SavePoint{seconds, changes})
+		fields = fields[2:]
+	}
+	return points, nil
+}
+
+// RunSavePoints checks the save points every second, starting a background
+// save when one is reached.
+func RunSavePoints(points []SavePoint) {
+	if len(points) == 0 {
+		return
+	}
+	for range time.Tick(time.Second) {
+		checkSavePoints(points)
+	}
+}
+
+func checkSavePoints(points []SavePoint) {
+	status := GetStatus()
+	now := time.Now()
+	mu.Lock()
+	retryAfter := lastBGSaveTry.Add(bgsaveRetryDelay)
+	mu.Unlock()
+	if !status.LastBGSaveOK && now.Before(retryAfter) {
+		return
+	}
+	dirty := cache.GetDefaultCache().Dirty()
+	for _, p := range points {
+		if dirty >= p.Changes && now.Sub(status.LastSave) >= time.Duration(p.Seconds)*time.Second {
+			log.Printf("[Persistence] %d changes in %d seconds. Saving...\n", p.Changes, p.Seconds)
+			if err := BGSave(); err != nil && err != ErrSaveInProgress {
+				log.Println("[Persistence] Error starting background save: ", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package reactor serves clients from an event loop, as an alternative to
+// running a goroutine per connection.
+//
+// Connections are spread across a fixed number of I/O threads, each of which
+// waits on its own epoll instance, reads and parses whatever input is ready,
+// and writes out whatever responses are pending. Parsed commands are handed
+// to a single command-execution thread, so commands are executed one at a
+// time, in the order they were received.
+package reactor
+
+import "strings"
+
+// clientAddr returns the address of `addr` with the port section removed.
+func clientAddr(addr string) string {
+	split := strings.Split(addr, ":")
+	return strings.Join(split[:len(split)-1], ":")
+}
@@ -0,0 +1,553 @@
+//go:build linux
+
+package reactor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"github.com/codecrafters-io/redis-starter-go/app/handler"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// Size of the per-thread read buffer.
+const readBufSize = 16 * 1024
+
+// Size of the queue between the I/O threads and the execution thread.
+const execQueueSize = 1024
+
+// batch is a run of commands read from a single connection in one go.
+type batch struct {
+	c    *conn
+	cmds []handler.CommandArgs
+	// eof is set once the client has stopped sending; the connection is
+	// closed once everything before it has been answered.
+	eof bool
+	// Set on the batch sent once a blocked command has finished, along with
+	// its response.
+	unblocked bool
+	resp      handler.CommandResponse
+}
+
+// execute runs every batch on the execution queue, one command at a time.
+//
+// A command that has to wait on other clients (e.g. WAIT) blocks its
+// connection, not the queue: it waits in the background, and the
+// connection's commands are held until it's done.
+func execute(queue chan *batch) {
+	// Blocked connections, with the input they've sent since.
+	blocked := make(map[*conn]*batch)
+	for b := range queue {
+		if b.unblocked {
+			b.c.queue(b.resp)
+			held := blocked[b.c]
+			delete(blocked, b.c)
+			b = held
+		} else if held, ok := blocked[b.c]; ok {
+			held.cmds = append(held.cmds, b.cmds...)
+			held.eof = held.eof || b.eof
+			continue
+		}
+		if held := runBatch(queue, b); held != nil {
+			blocked[b.c] = held
+			b.c.t.wake(b.c)
+			continue
+		}
+		if b.eof {
+			b.c.closeWhenFlushed()
+		}
+		b.c.t.wake(b.c)
+	}
+}
+
+// runBatch runs the commands in `b`. If one of them blocks, the rest of the
+// batch is returned, to be run once it's done.
+func runBatch(queue chan<- *batch, b *batch) *batch {
+	for i, command := range b.cmds {
+		cmd, ok := command[0].(string)
+		if !ok {
+			log.Printf("[Reactor] Invalid command name: %#v\n", command[0])
+			b.c.queue(handler.CommandResponse{[]byte("-ERR invalid command name\r\n")})
+			continue
+		}
+		cmdCtx := &handler.Ctx{}
+		cmdCtx.SetCmd(cmd)
+		cmdCtx.SetArgs(command[1:])
+		cmdCtx.SetClientAddr(b.c.clientAddr)
+		cmdCtx.SetConn(b.c)
+		resp, wait := handler.HandleAsync(cmdCtx)
+		if wait != nil {
+			go func(c *conn) {
+				queue <- &batch{c: c, unblocked: true, resp: wait()}
+			}(b.c)
+			return &batch{c: b.c, cmds: b.cmds[i+1:], eof: b.eof}
+		}
+		b.c.queue(resp)
+	}
+	return nil
+}
+
+// Reactor accepts connections and hands them off to its I/O threads.
+type Reactor struct {
+	threads []*ioThread
+	exec    chan *batch
+	next    int
+}
+
+// New starts `ioThreads` I/O threads and the command-execution thread.
+func New(ioThreads int) (*Reactor, error) {
+	if ioThreads < 1 {
+		return nil, fmt.Errorf("invalid number of I/O threads: %d", ioThreads)
+	}
+	r := &Reactor{exec: make(chan *batch, execQueueSize)}
+	for i := 0; i < ioThreads; i++ {
+		t, err := newIOThread(r.exec)
+		if err != nil {
+			return nil, err
+		}
+		r.threads = append(r.threads, t)
+		go t.loop()
+	}
+	go func() {
+		runtime.LockOSThread()
+		execute(r.exec)
+	}()
+	return r, nil
+}
+
+// Serve accepts connections on `l` until it is closed, assigning each one to
+// an I/O thread in turn.
+func (r *Reactor) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		t := r.threads[r.next]
+		r.next = (r.next + 1) % len(r.threads)
+		if err := t.register(nc); err != nil {
+			log.Println("[Reactor] Error registering connection: ", err)
+			nc.Close()
+		}
+	}
+}
+
+// Shutdown writes out any pending output and closes every connection. The
+// listener passed to Serve should be closed first.
+func (r *Reactor) Shutdown() {
+	for _, t := range r.threads {
+		t.stop()
+	}
+}
+
+// conn is a client connection owned by an I/O thread.
+//
+// It implements net.Conn so that it can be handed to command handlers; writes
+// are appended to the output buffer and picked up by the I/O thread, keeping
+// them in order with command responses.
+type conn struct {
+	net.Conn
+	fd         int
+	t          *ioThread
+	clientAddr string
+	// Input that hasn't been parsed into a command yet; only touched by the
+	// I/O thread.
+	in []byte
+	// Whether we've stopped watching for input.
+	eof bool
+	// Events the connection is currently registered for.
+	events uint32
+
+	mu      sync.Mutex
+	out     []byte
+	closing bool
+	closed  bool
+}
+
+// Write appends `p` to the output buffer and wakes the I/O thread.
+func (c *conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.closed || c.closing {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	c.out = append(c.out, p...)
+	c.mu.Unlock()
+	c.t.wake(c)
+	return len(p), nil
+}
+
+// Close discards any pending output and has the I/O thread close the
+// connection. The I/O thread owns the descriptor, so it's only closed there,
+// once it's no longer watched, and can't be used again after it's reused.
+func (c *conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.closing = true
+	c.out = nil
+	c.mu.Unlock()
+	c.t.wake(c)
+	return nil
+}
+
+// Buffered returns the number of bytes written to the connection that
+// haven't been sent yet.
+func (c *conn) Buffered() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.out)
+}
+
+// queue appends a command response to the output buffer, without waking the
+// I/O thread; see execute.
+func (c *conn) queue(resp handler.CommandResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.closing {
+		return
+	}
+	for _, r := range resp {
+		c.out = append(c.out, r...)
+	}
+}
+
+// closeWhenFlushed marks the connection to be closed once its output buffer
+// has been written out.
+func (c *conn) closeWhenFlushed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closing = true
+}
+
+type ioThread struct {
+	epfd int
+	// Self-pipe used to wake the thread from epoll_wait.
+	wakeR, wakeW int
+	exec         chan<- *batch
+
+	mu       sync.Mutex
+	conns    map[int]*conn
+	pending  []*conn
+	woken    bool
+	stopping bool
+	// Closed once the thread has stopped.
+	done chan struct{}
+}
+
+func newIOThread(exec chan<- *batch) (*ioThread, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll_create1: %w", err)
+	}
+	p := make([]int, 2)
+	if err := syscall.Pipe2(p, syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, fmt.Errorf("pipe2: %w", err)
+	}
+	ev := &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p[0], ev); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(p[0])
+		syscall.Close(p[1])
+		return nil, fmt.Errorf("epoll_ctl: %w", err)
+	}
+	return &ioThread{
+		epfd:  epfd,
+		wakeR: p[0],
+		wakeW: p[1],
+		exec:  exec,
+		conns: make(map[int]*conn),
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// register starts watching `nc` for input.
+func (t *ioThread) register(nc net.Conn) error {
+	sc, ok := nc.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("unsupported connection type %T", nc)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// The runtime has already made the descriptor non-blocking. We keep `nc`
+	// around so the descriptor stays open for as long as we're using it.
+	fd := -1
+	if err := rc.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return err
+	}
+
+	c := &conn{
+		Conn:       nc,
+		fd:         fd,
+		t:          t,
+		clientAddr: clientAddr(nc.LocalAddr().String()),
+		events:     syscall.EPOLLIN | syscall.EPOLLRDHUP,
+	}
+	t.mu.Lock()
+	t.conns[fd] = c
+	t.mu.Unlock()
+	ev := &syscall.EpollEvent{Events: c.events, Fd: int32(fd)}
+	if err := syscall.EpollCtl(t.epfd, syscall.EPOLL_CTL_ADD, fd, ev); err != nil {
+		t.mu.Lock()
+		delete(t.conns, fd)
+		t.mu.Unlock()
+		return fmt.Errorf("epoll_ctl: %w", err)
+	}
+	return nil
+}
+
+// wake schedules `c` to have its output written.
+func (t *ioThread) wake(c *conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, c)
+	t.signal()
+}
+
+// stop closes every connection once pending output has been written, and
+// waits for the thread to exit.
+func (t *ioThread) stop() {
+	t.mu.Lock()
+	t.stopping = true
+	t.signal()
+	t.mu.Unlock()
+	<-t.done
+}
+
+// signal wakes the thread from epoll_wait; t.mu must be held.
+func (t *ioThread) signal() {
+	if t.woken {
+		return
+	}
+	t.woken = true
+	// The pipe only needs to be readable; a full pipe is as good as a write.
+	syscall.Write(t.wakeW, []byte{0})
+}
+
+func (t *ioThread) loop() {
+	runtime.LockOSThread()
+	events := make([]syscall.EpollEvent, 128)
+	buf := make([]byte, readBufSize)
+	for {
+		n, err := syscall.EpollWait(t.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Println("[Reactor] epoll_wait: ", err)
+			return
+		}
+		for _, ev := range events[:n] {
+			fd := int(ev.Fd)
+			if fd == t.wakeR {
+				if stopping := t.flushPending(); stopping {
+					t.closeAll()
+					close(t.done)
+					return
+				}
+				continue
+			}
+			t.mu.Lock()
+			c := t.conns[fd]
+			t.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			if ev.Events&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				t.read(c, buf)
+			}
+			if ev.Events&syscall.EPOLLOUT != 0 {
+				t.write(c)
+			}
+		}
+	}
+}
+
+// flushPending drains the wake pipe and writes out every connection that has
+// been scheduled since the last wake. Returns whether the thread is stopping.
+func (t *ioThread) flushPending() bool {
+	drain := make([]byte, 64)
+	for {
+		if _, err := syscall.Read(t.wakeR, drain); err != nil {
+			break
+		}
+	}
+	t.mu.Lock()
+	pending := t.pending
+	stopping := t.stopping
+	t.pending = nil
+	t.woken = false
+	t.mu.Unlock()
+	for _, c := range pending {
+		t.write(c)
+	}
+	return stopping
+}
+
+// closeAll makes a last attempt at writing out each connection's output, and
+// closes it.
+func (t *ioThread) closeAll() {
+	t.mu.Lock()
+	conns := make([]*conn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+	for _, c := range conns {
+		t.write(c)
+		t.close(c)
+	}
+}
+
+// read reads whatever input is available on `c` and passes any complete
+// commands on to the execution thread.
+func (t *ioThread) read(c *conn, buf []byte) {
+	if c.eof {
+		return
+	}
+	for {
+		n, err := syscall.Read(c.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil || n == 0 {
+			// The client is done sending, but may still be waiting on
+			// responses for what it has sent.
+			t.stopReading(c)
+			break
+		}
+		c.in = append(c.in, buf[:n]...)
+		if n < len(buf) {
+			break
+		}
+	}
+
+	var cmds []handler.CommandArgs
+	consumed := 0
+	for consumed < len(c.in) {
+		parsed, n, err := parser.ParseRESPBuffer(c.in[consumed:])
+		if errors.Is(err, parser.ErrIncomplete) {
+			break
+		}
+		if err != nil {
+			log.Println("[Reactor] Error parsing command: ", err)
+			t.stopReading(c)
+			break
+		}
+		consumed += n
+		// Assert `parsed` is of form CommandArgs
+		command, ok := parsed.(handler.CommandArgs)
+		if !ok || len(command) == 0 {
+			t.stopReading(c)
+			break
+		}
+		cmds = append(cmds, command)
+	}
+	// Keep any partial command for the next read.
+	c.in = append(c.in[:0:0], c.in[consumed:]...)
+
+	if len(cmds) > 0 || c.eof {
+		t.exec <- &batch{c: c, cmds: cmds, eof: c.eof}
+	}
+}
+
+// stopReading stops watching `c` for input, once the client's done sending or
+// sent something we can't parse. It's closed once what came before has been
+// answered.
+func (t *ioThread) stopReading(c *conn) {
+	c.eof = true
+	t.setEvents(c, c.events&^(syscall.EPOLLIN|syscall.EPOLLRDHUP))
+}
+
+// write writes out as much of the output buffer as the socket will take,
+// waiting for EPOLLOUT if there's more.
+func (t *ioThread) write(c *conn) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	for len(c.out) > 0 {
+		n, err := syscall.Write(c.fd, c.out)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			c.mu.Unlock()
+			log.Println("[Reactor] Error writing command response: ", err)
+			t.close(c)
+			return
+		}
+		c.out = c.out[n:]
+	}
+	events := c.events
+	if len(c.out) > 0 {
+		events |= syscall.EPOLLOUT
+	} else {
+		c.out = nil
+		events &^= syscall.EPOLLOUT
+	}
+	closing := c.closing && len(c.out) == 0
+	c.mu.Unlock()
+
+	if closing {
+		t.close(c)
+		return
+	}
+	t.setEvents(c, events)
+}
+
+// setEvents updates the events `c` is watched for. A connection that isn't
+// waiting on any events is taken out of the epoll set altogether, since
+// EPOLLHUP and EPOLLERR can't be masked, and would otherwise keep waking us
+// once the client's hung up.
+func (t *ioThread) setEvents(c *conn, events uint32) {
+	if events == c.events {
+		return
+	}
+	op := syscall.EPOLL_CTL_MOD
+	if events == 0 {
+		op = syscall.EPOLL_CTL_DEL
+	} else if c.events == 0 {
+		op = syscall.EPOLL_CTL_ADD
+	}
+	c.events = events
+	ev := &syscall.EpollEvent{Events: events, Fd: int32(c.fd)}
+	if err := syscall.EpollCtl(t.epfd, op, c.fd, ev); err != nil {
+		log.Println("[Reactor] epoll_ctl: ", err)
+	}
+}
+
+func (t *ioThread) close(c *conn) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.out = nil
+	c.mu.Unlock()
+
+	syscall.EpollCtl(t.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	t.mu.Lock()
+	delete(t.conns, c.fd)
+	t.mu.Unlock()
+	c.Conn.Close()
+	handler.ConnClosed(c)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package reactor
+
+import (
+	"errors"
+	"net"
+)
+
+// Reactor is only available on linux, where it's backed by epoll.
+type Reactor struct{}
+
+// New always fails; see Reactor.
+func New(ioThreads int) (*Reactor, error) {
+	return nil, errors.New("event loop is only supported on linux")
+}
+
+// Serve always fails; see Reactor.
+func (r *Reactor) Serve(l net.Listener) error {
+	return errors.New("event loop is only supported on linux")
+}
+
+// Shutdown does nothing; see Reactor.
+func (r *Reactor) Shutdown() {}
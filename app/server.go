@@ -2,17 +2,25 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/cache"
 	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/handler"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/persistence"
+	"github.com/codecrafters-io/redis-starter-go/app/reactor"
 )
 
 func main() {
@@ -24,33 +32,44 @@ func main() {
 	replicaof, _ := config.Get("replicaof")
 	port, _ := config.Get("port")
 
-	// Load from rdb file.
-	rdbFilepath := filepath.Join(dir, dbfilename)
-	rdbFile, err := os.Open(rdbFilepath)
-	if !os.IsNotExist(err) {
+	// Load from the AOF if it's enabled, falling back to the rdb file.
+	appendonly, _ := config.Get("appendonly")
+	loaded := false
+	if appendonly == "yes" {
+		var err error
+		loaded, err = persistence.LoadAOF(replayCommand)
 		if err != nil {
-			log.Fatal("[main] Unable to open rdb file: ", err.Error())
+			log.Fatal("[main] Unable to load AOF: ", err.Error())
 		}
-		defer rdbFile.Close()
-		resp := parser.NewRDBParser(rdbFile).Parse()
-		err = cache.GetDefaultCache().LoadRDB(resp)
-		if err != nil {
-			log.Fatal("[main] Unable to load RDB data into cache: ", err.Error())
+	}
+	if !loaded {
+		loadRDB(filepath.Join(dir, dbfilename))
+	}
+	if appendonly == "yes" {
+		if err := persistence.OpenAOF(); err != nil {
+			log.Fatal("[main] Unable to open AOF: ", err.Error())
 		}
 	}
 
+	go handler.RunActiveExpire()
+
+	// Schedule background saves.
+	savePoints, err := persistence.SavePoints()
+	if err != nil {
+		log.Fatal("[main] ", err.Error())
+	}
+	go persistence.RunSavePoints(savePoints)
+	go handler.RunReplicationCron()
+
 	// Initialize replication.
 	if replicaof != "" {
 		// replicaof is formatted like "<MASTER HOST> <MASTER PORT>"
 		// we want an address like "<MASTER HOST>:<MASTER PORT>"
 		address := strings.Replace(replicaof, " ", ":", 1)
 
-		replClient := handler.NewReplicationClient(address)
-		err := replClient.Init()
-		if err != nil {
-			log.Fatal("[main] Replication error:", err)
-		}
-		go replClient.Handle()
+		// The master may not be up yet, or go away later; the client keeps
+		// trying to (re)connect in the background.
+		handler.StartReplication(address)
 	}
 
 	// Run listener.
@@ -62,46 +81,235 @@ func main() {
 	}
 	log.Println("[main] Listening on", addr)
 
+	go handleSignals()
+
+	if eventLoop, _ := config.Get("event-loop"); eventLoop == "yes" {
+		ioThreads, _ := config.Get("io-threads")
+		n, err := strconv.Atoi(ioThreads)
+		if err != nil {
+			log.Fatal("[main] Invalid io-threads: ", ioThreads)
+		}
+		r, err := reactor.New(n)
+		if err != nil {
+			log.Fatal("[main] Unable to start event loop: ", err.Error())
+		}
+		go func() {
+			if err := r.Serve(l); !errors.Is(err, net.ErrClosed) {
+				log.Fatal("[main] Error accepting connection: ", err.Error())
+			}
+		}()
+		<-handler.ShuttingDown()
+		l.Close()
+		r.Shutdown()
+	} else {
+		go acceptConns(l)
+		<-handler.ShuttingDown()
+		l.Close()
+		if !closeConns(drainTimeout) {
+			log.Println("[main] Timed out waiting for connections to close")
+			os.Exit(1)
+		}
+	}
+	log.Println("[main] Shut down")
+}
+
+// loadRDB loads the cache from the rdb file at `rdbFilepath`, if it exists.
+func loadRDB(rdbFilepath string) {
+	rdbFile, err := os.Open(rdbFilepath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatal("[main] Unable to open rdb file: ", err.Error())
+	}
+	defer rdbFile.Close()
+	info, err := cache.GetDefaultCache().LoadRDB(bufio.NewReader(rdbFile))
+	if err != nil {
+		// Refuse to start rather than run (and later save over the file)
+		// without its data.
+		log.Fatalf("[main] Refusing to start with a corrupt rdb file %s: %s", rdbFilepath, err.Error())
+	}
+	handler.RestoreReplicationInfo(info.Aux)
+}
+
+// replayCommand executes a command logged to the AOF.
+func replayCommand(command []interface{}) {
+	cmd, ok := command[0].(string)
+	if !ok {
+		log.Printf("[main] Unexpected command in AOF: %#v\n", command)
+		return
+	}
+	cmdCtx := &handler.Ctx{}
+	cmdCtx.SetCmd(cmd)
+	cmdCtx.SetArgs(command[1:])
+	cmdCtx.SetReplayed()
+	handler.Handle(cmdCtx)
+}
+
+// handleSignals shuts the server down on SIGINT or SIGTERM.
+func handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range sigs {
+		log.Printf("[main] Received %s, scheduling shutdown...\n", sig)
+		if err := handler.Shutdown(handler.ShutdownDefault); err != nil {
+			log.Println("[main] Unable to shut down: ", err.Error())
+		}
+	}
+}
+
+// How long to wait for connections to finish writing once shutting down.
+const drainTimeout = 5 * time.Second
+
+// Open client connections, so they can be closed on shutdown.
+var (
+	connsMu sync.Mutex
+	conns   = make(map[*clientConn]struct{})
+	connsWg sync.WaitGroup
+)
+
+func acceptConns(l net.Listener) {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.Fatal("[main] Error accepting connection: ", err.Error())
 		}
-		go handleConn(conn)
+		cc := newClientConn(conn)
+		connsMu.Lock()
+		conns[cc] = struct{}{}
+		connsMu.Unlock()
+		connsWg.Add(1)
+		go func() {
+			defer connsWg.Done()
+			handleConn(cc)
+			connsMu.Lock()
+			delete(conns, cc)
+			connsMu.Unlock()
+		}()
 	}
 }
 
-func handleConn(conn net.Conn) {
-	defer conn.Close()
-	scanner := bufio.NewScanner(conn)
+// closeConns interrupts every connection's pending read, so it flushes its
+// responses and closes. Returns false if they don't all finish in `timeout`.
+func closeConns(timeout time.Duration) bool {
+	connsMu.Lock()
+	for cc := range conns {
+		cc.SetReadDeadline(time.Now())
+	}
+	connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		connsWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func handleConn(cc *clientConn) {
+	defer handler.ConnClosed(cc)
+	defer cc.Close()
+	defer cc.flush()
+	reader := bufio.NewReader(cc)
 
 	// Get the clientAddr from conn, with the port section removed.
-	split := strings.Split(conn.LocalAddr().String(), ":")
+	split := strings.Split(cc.LocalAddr().String(), ":")
 	clientAddr := strings.Join(split[:len(split)-1], ":")
 
 	// The data we receive is a command in the form of an array, where the first
 	// element is the command and the rest are optional args.
 	for {
 		cmdCtx := &handler.Ctx{}
-		parsed := parser.NewRESPParser(scanner).Parse()
+		parsed := parser.NewRESPParser(reader).Parse()
 		// Assert `parsed` is of form CommandArgs
 		command, ok := parsed.(handler.CommandArgs)
 		if !ok || len(command) == 0 {
 			break
 		}
-		cmdCtx.SetCmd(command[0].(string))
+		cmd, ok := command[0].(string)
+		if !ok {
+			log.Printf("[main] Invalid command name: %#v\n", command[0])
+			if err := cc.writeBuffered(handler.CommandResponse{[]byte("-ERR invalid command name\r\n")}); err != nil {
+				break
+			}
+			continue
+		}
+		cmdCtx.SetCmd(cmd)
 		cmdCtx.SetArgs(command[1:])
 		cmdCtx.SetClientAddr(clientAddr)
-		cmdCtx.SetConn(conn)
-		for _, resp := range handler.Handle(cmdCtx) {
-			// Without _something_ here reading resp though some sort of
-			// formatting, the RDB data from PSYNC won't actually write out.
-			// ¯\_(ツ)_/¯
-			_ = fmt.Sprint(resp)
-			_, err := conn.Write(resp)
-			if err != nil {
-				log.Println("[main] Error writing command response: ", err.Error())
-			}
+		cmdCtx.SetConn(cc)
+		// Responses are buffered; they're flushed once we've run out of
+		// pipelined input and go back to the socket for more.
+		if err := cc.writeBuffered(handler.Handle(cmdCtx)); err != nil {
+			log.Println("[main] Error writing command response: ", err.Error())
+			break
 		}
 	}
 }
+
+// clientConn wraps a client connection with a buffered writer, so that a
+// pipeline of commands is answered with as few writes as possible.
+//
+// Responses from the connection's own command loop are buffered, and only
+// flushed when the loop needs to read more input. Writes from anywhere else
+// (e.g. replication) go through Write, which is ordered after any buffered
+// responses and flushed immediately.
+type clientConn struct {
+	net.Conn
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newClientConn(conn net.Conn) *clientConn {
+	return &clientConn{Conn: conn, w: bufio.NewWriter(conn)}
+}
+
+// Read flushes any buffered responses before reading from the connection.
+// Readers (like bufio.Reader) only call Read once their own buffer has been
+// drained, so this is the point where the pipeline has been fully answered.
+func (c *clientConn) Read(p []byte) (int, error) {
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// Write writes `p` after any buffered responses, and flushes.
+func (c *clientConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.w.Flush()
+}
+
+// writeBuffered adds `resp` to the write buffer without flushing.
+func (c *clientConn) writeBuffered(resp handler.CommandResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range resp {
+		if _, err := c.w.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clientConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w.Buffered() == 0 {
+		return nil
+	}
+	return c.w.Flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/handler"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// Commands sent per pipeline in the benchmarks.
+const benchPipelineLen = 1000
+
+// BenchmarkPipelinedSET measures a pipeline of SETs answered by handleConn,
+// which buffers responses and flushes once the pipeline's been read.
+func BenchmarkPipelinedSET(b *testing.B) {
+	benchmarkPipeline(b, func(conn net.Conn) {
+		handleConn(newClientConn(conn))
+	})
+}
+
+// BenchmarkPipelinedSETUnbuffered measures the same pipeline answered with a
+// write per response element, as handleConn did before responses were
+// buffered; compare with BenchmarkPipelinedSET.
+func BenchmarkPipelinedSETUnbuffered(b *testing.B) {
+	benchmarkPipeline(b, func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			command, ok := parser.NewRESPParser(reader).Parse().(handler.CommandArgs)
+			if !ok || len(command) == 0 {
+				return
+			}
+			cmdCtx := &handler.Ctx{}
+			cmdCtx.SetCmd(command[0].(string))
+			cmdCtx.SetArgs(command[1:])
+			cmdCtx.SetConn(conn)
+			for _, r := range handler.Handle(cmdCtx) {
+				if _, err := conn.Write(r); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// benchmarkPipeline serves connections on a loopback listener with `serve`,
+// and sends it benchPipelineLen SETs per iteration, reading all the replies.
+func benchmarkPipeline(b *testing.B, serve func(net.Conn)) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	var pipeline bytes.Buffer
+	for i := 0; i < benchPipelineLen; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		fmt.Fprintf(&pipeline, "*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$5\r\nvalue\r\n", len(key), key)
+	}
+	replies := make([]byte, benchPipelineLen*len("+OK\r\n"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(pipeline.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, replies); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// parseFile parses the RDB file at `path`, calling `fn` with each entry.
+func parseFile(path string, fn func(parser.RDBEntry) error) (parser.RDBInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return parser.RDBInfo{}, err
+	}
+	defer f.Close()
+	return parser.ParseRDB(bufio.NewReader(f), fn)
+}
+
+// typeName returns the name TYPE would report for `v`.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case []byte:
+		return "string"
+	case parser.RDBList:
+		return "list"
+	case parser.RDBSet:
+		return "set"
+	case parser.RDBSortedSet:
+		return "zset"
+	case parser.RDBHash:
+		return "hash"
+	default:
+		return "unknown"
+	}
+}
+
+// valueSize returns the number of elements in `v`, and the bytes of data they
+// hold. Sorted set scores count as 8 bytes.
+func valueSize(v interface{}) (elems, size int) {
+	switch v := v.(type) {
+	case []byte:
+		return 1, len(v)
+	case parser.RDBList:
+		for _, e := range v {
+			size += len(e)
+		}
+		return len(v), size
+	case parser.RDBSet:
+		for _, e := range v {
+			size += len(e)
+		}
+		return len(v), size
+	case parser.RDBSortedSet:
+		for _, e := range v {
+			size += len(e.Member) + 8
+		}
+		return len(v), size
+	case parser.RDBHash:
+		for _, e := range v {
+			size += len(e.Field) + len(e.Value)
+		}
+		return len(v), size
+	default:
+		return 0, 0
+	}
+}
+
+// ttl formats the time left before `expiry`, as TTL would report it.
+func ttl(expiry time.Time, now time.Time) string {
+	if expiry.IsZero() {
+		return "-1"
+	}
+	if d := expiry.Sub(now); d > 0 {
+		return d.Round(time.Millisecond).String()
+	}
+	return "expired"
+}
+
+// dump prints one line per key: key, type, TTL, elements, and size.
+func runDump(args []string, out *bufio.Writer) error {
+	path, err := fileArg(flag.NewFlagSet("dump", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	fmt.Fprintln(out, "key\ttype\tttl\telements\tbytes")
+	_, err = parseFile(path, func(e parser.RDBEntry) error {
+		elems, size := valueSize(e.Value)
+		_, err := fmt.Fprintf(out, "%q\t%s\t%s\t%d\t%d\n", e.Key, typeName(e.Value), ttl(e.Expiry, now), elems, len(e.Key)+size)
+		return err
+	})
+	return err
+}
+
+type prefixStats struct {
+	prefix string
+	keys   int
+	bytes  int
+}
+
+// stats totals keys and bytes by key prefix, the first `depth` segments of
+// the key split on `sep`, largest first.
+func runStats(args []string, out *bufio.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	sep := fs.String("sep", ":", "key segment separator")
+	depth := fs.Int("depth", 1, "number of key segments in a prefix")
+	path, err := fileArg(fs, args)
+	if err != nil {
+		return err
+	}
+	byPrefix := make(map[string]*prefixStats)
+	byType := make(map[string]int)
+	var keys, bytes, expires int
+	info, err := parseFile(path, func(e parser.RDBEntry) error {
+		_, size := valueSize(e.Value)
+		size += len(e.Key)
+		prefix := string(e.Key)
+		if segments := strings.SplitN(prefix, *sep, *depth+1); len(segments) > *depth {
+			prefix = strings.Join(segments[:*depth], *sep) + *sep + "*"
+		}
+		ps, ok := byPrefix[prefix]
+		if !ok {
+			ps = &prefixStats{prefix: prefix}
+			byPrefix[prefix] = ps
+		}
+		ps.keys++
+		ps.bytes += size
+		byType[typeName(e.Value)]++
+		keys++
+		bytes += size
+		if !e.Expiry.IsZero() {
+			expires++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "version: %d\n", info.Version)
+	auxKeys := make([]string, 0, len(info.Aux))
+	for k := range info.Aux {
+		auxKeys = append(auxKeys, k)
+	}
+	sort.Strings(auxKeys)
+	for _, k := range auxKeys {
+		fmt.Fprintf(out, "aux %s: %s\n", k, info.Aux[k])
+	}
+	fmt.Fprintf(out, "keys: %d (%d with expiry)\n", keys, expires)
+	fmt.Fprintf(out, "bytes: %d\n", bytes)
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(out, "type %s: %d\n", t, byType[t])
+	}
+
+	prefixes := make([]*prefixStats, 0, len(byPrefix))
+	for _, ps := range byPrefix {
+		prefixes = append(prefixes, ps)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].bytes != prefixes[j].bytes {
+			return prefixes[i].bytes > prefixes[j].bytes
+		}
+		return prefixes[i].prefix < prefixes[j].prefix
+	})
+	fmt.Fprint(out, "\nprefix\tkeys\tbytes\tpercent\n")
+	for _, ps := range prefixes {
+		fmt.Fprintf(out, "%q\t%d\t%d\t%.1f\n", ps.prefix, ps.keys, ps.bytes, 100*float64(ps.bytes)/float64(max(bytes, 1)))
+	}
+	return nil
+}
+
+// check parses the whole file, reporting the first error and where it is.
+func runCheck(args []string, out *bufio.Writer) error {
+	path, err := fileArg(flag.NewFlagSet("check", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	keys := 0
+	info, err := parseFile(path, func(parser.RDBEntry) error {
+		keys++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: invalid after %d keys: %w", path, keys, err)
+	}
+	fmt.Fprintf(out, "%s: OK, RDB version %d, %d keys\n", path, info.Version, keys)
+	return nil
+}
+
+type jsonEntry struct {
+	Key    string      `json:"key"`
+	Type   string      `json:"type"`
+	Value  interface{} `json:"value"`
+	Expiry int64       `json:"expiry_ms,omitempty"` // Unix time in ms
+}
+
+// json prints the dataset as a JSON array, one entry per line. Strings that
+// aren't valid UTF-8 have the invalid bytes replaced.
+func runJSON(args []string, out *bufio.Writer) error {
+	path, err := fileArg(flag.NewFlagSet("json", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, "[")
+	first := true
+	_, err = parseFile(path, func(e parser.RDBEntry) error {
+		je := jsonEntry{Key: string(e.Key), Type: typeName(e.Value), Value: jsonValue(e.Value)}
+		if !e.Expiry.IsZero() {
+			je.Expiry = e.Expiry.UnixMilli()
+		}
+		b, err := json.Marshal(je)
+		if err != nil {
+			return err
+		}
+		if !first {
+			fmt.Fprint(out, ",")
+		}
+		first = false
+		fmt.Fprintf(out, "\n%s", b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "\n]")
+	return nil
+}
+
+// jsonValue converts `v` to strings, arrays of strings, or objects.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case parser.RDBList:
+		return stringsOf(v)
+	case parser.RDBSet:
+		return stringsOf(v)
+	case parser.RDBSortedSet:
+		// Scores can be infinite, which JSON numbers can't hold.
+		zset := make(map[string]string, len(v))
+		for _, e := range v {
+			zset[string(e.Member)] = strconv.FormatFloat(e.Score, 'g', -1, 64)
+		}
+		return zset
+	case parser.RDBHash:
+		hash := make(map[string]string, len(v))
+		for _, e := range v {
+			hash[string(e.Field)] = string(e.Value)
+		}
+		return hash
+	default:
+		return nil
+	}
+}
+
+func stringsOf(elems [][]byte) []string {
+	strs := make([]string, 0, len(elems))
+	for _, e := range elems {
+		strs = append(strs, string(e))
+	}
+	return strs
+}
+
+// resp prints the commands that recreate the dataset, as RESP arrays, ready to
+// pipe into a server (e.g. with `redis-cli --pipe`).
+func runRESP(args []string, out *bufio.Writer) error {
+	path, err := fileArg(flag.NewFlagSet("resp", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	_, err = parseFile(path, func(e parser.RDBEntry) error {
+		for _, cmd := range commandsFor(e) {
+			writeCommand(out, cmd)
+		}
+		return nil
+	})
+	return err
+}
+
+// Largest number of members (or field/value pairs) added per command.
+const batchSize = 128
+
+// commandsFor returns the commands that recreate `e`.
+func commandsFor(e parser.RDBEntry) [][][]byte {
+	var cmds [][][]byte
+	// batched splits `elems` into commands of `cmd` `key` followed by up to
+	// batchSize groups of `n` elements.
+	batched := func(cmd string, n int, elems [][]byte) {
+		for len(elems) > 0 {
+			end := min(len(elems), batchSize*n)
+			cmds = append(cmds, append([][]byte{[]byte(cmd), e.Key}, elems[:end]...))
+			elems = elems[end:]
+		}
+	}
+	switch v := e.Value.(type) {
+	case []byte:
+		cmds = append(cmds, [][]byte{[]byte("SET"), e.Key, v})
+	case parser.RDBList:
+		batched("RPUSH", 1, v)
+	case parser.RDBSet:
+		batched("SADD", 1, v)
+	case parser.RDBSortedSet:
+		elems := make([][]byte, 0, 2*len(v))
+		for _, z := range v {
+			elems = append(elems, []byte(strconv.FormatFloat(z.Score, 'g', -1, 64)), z.Member)
+		}
+		batched("ZADD", 2, elems)
+	case parser.RDBHash:
+		elems := make([][]byte, 0, 2*len(v))
+		for _, h := range v {
+			elems = append(elems, h.Field, h.Value)
+		}
+		batched("HSET", 2, elems)
+	}
+	if !e.Expiry.IsZero() {
+		cmds = append(cmds, [][]byte{[]byte("PEXPIREAT"), e.Key, []byte(strconv.FormatInt(e.Expiry.UnixMilli(), 10))})
+	}
+	return cmds
+}
+
+func writeCommand(out *bufio.Writer, cmd [][]byte) {
+	fmt.Fprintf(out, "*%d\r\n", len(cmd))
+	for _, arg := range cmd {
+		fmt.Fprintf(out, "$%d\r\n", len(arg))
+		out.Write(arg)
+		out.WriteString("\r\n")
+	}
+}
@@ -0,0 +1,77 @@
+// rdbtool inspects RDB files offline, using the server's RDB parser.
+//
+//	rdbtool dump <file>                       print each key's type, TTL, and size
+//	rdbtool stats [-sep :] [-depth 1] <file>  summarize key counts and sizes by prefix
+//	rdbtool check <file>                      validate the file's structure and checksum
+//	rdbtool json <file>                       print the dataset as JSON
+//	rdbtool resp <file>                       print the dataset as RESP commands
+//
+// Sizes are the bytes of key and element data, not the server's memory
+// overhead. Pass -v before the command to include the parser's logging.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, out *bufio.Writer) error
+}
+
+var commands = map[string]command{
+	"dump":  {"dump <file>", runDump},
+	"stats": {"stats [-sep :] [-depth 1] <file>", runStats},
+	"check": {"check <file>", runCheck},
+	"json":  {"json <file>", runJSON},
+	"resp":  {"resp <file>", runRESP},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: rdbtool [-v] <command> [args]")
+	for _, name := range []string{"dump", "stats", "check", "json", "resp"} {
+		fmt.Fprintln(os.Stderr, "  rdbtool", commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	verbose := flag.Bool("v", false, "log parser output to stderr")
+	flag.Usage = usage
+	flag.Parse()
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	if flag.NArg() < 1 {
+		usage()
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	out := bufio.NewWriter(os.Stdout)
+	err := cmd.run(flag.Args()[1:], out)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rdbtool:", err)
+		os.Exit(1)
+	}
+}
+
+// fileArg returns the single file argument in `args`.
+func fileArg(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("expected a single file argument")
+	}
+	return fs.Arg(0), nil
+}